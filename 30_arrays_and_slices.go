@@ -6,7 +6,11 @@ There are major differences between the ways ARRAYS work in Go and C. In Go,
 */
 package main
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/golang-tests/geometry"
+)
 
 func Sum(a *[3]float64) (sum float64) {
 	for _, v := range *a {
@@ -33,8 +37,8 @@ func customAppend(slice, data []byte) []byte {
 // Go's arrays and slices are one-dimensional.
 // To create the equivalent of a 2D array or slice,
 // it is necessary to define an array-of-arrays or slice-of-slices, like this:
-type Transform [3][3]float64 // A 3x3 array, really an array of arrays.
-type LinesOfText [][]byte    // A slice of byte slices.
+type Transform = geometry.Transform // A 3x3 array, really an array of arrays.
+type LinesOfText [][]byte           // A slice of byte slices.
 
 func main() {
 
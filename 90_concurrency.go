@@ -25,6 +25,7 @@ package main
 
 import (
 	"github.com/golang-tests/concurrency"
+	"github.com/golang-tests/pipeline"
 )
 
 func main() {
@@ -32,5 +33,7 @@ func main() {
 	concurrency.SimpleChannelExample()
 	concurrency.MultiChannelExample()
 	concurrency.ParallelizationExample()
+	concurrency.PoolExample()
+	pipeline.SumExample()
 
 }
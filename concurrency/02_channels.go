@@ -19,24 +19,35 @@ that two calculations (goroutines) are in a known state.
 
 // === SIMPLE CHANNEL EXAMPLE ===
 
-func sumWithChan(s []int, c chan int) {
+// partialSum is the sum of one piece of a slice, tagged with the index of the piece.
+type partialSum struct {
+	index, sum int
+}
+
+func sumWithChan(i int, s []int, c chan partialSum) {
 	sum := 0
 	for _, v := range s {
 		sum += v
 	}
-	c <- sum // send sum to c
+	c <- partialSum{i, sum} // send sum to c
 }
 
 func SimpleChannelExample() {
 	fmt.Println("=== Simple Channel Example ===")
 
 	s := []int{7, 2, 8, -9, 4, 0}
-	c := make(chan int)
+	c := make(chan partialSum)
 
-	go sumWithChan(s[:len(s)/2], c)
-	go sumWithChan(s[len(s)/2:], c)
+	go sumWithChan(0, s[:len(s)/2], c)
+	go sumWithChan(1, s[len(s)/2:], c)
 
-	x, y := <-c, <-c
-	// receive from c
+	// The sums arrive in whatever order the goroutines finish;
+	// the index puts each back in its place.
+	var sums [2]int
+	for range sums {
+		p := <-c // receive from c
+		sums[p.index] = p.sum
+	}
+	x, y := sums[0], sums[1]
 	fmt.Println(x, y, x+y)
 }
@@ -1,36 +1,325 @@
 package concurrency
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 // === MULTI CHANNEL EXAMPLE ===
 
 type Request struct {
+	name       string // of the registered handler, if f is nil
 	args       []int
-	f          func([]int) int
-	resultChan chan int
+	f          func([]int) (int, error)
+	resultChan chan Result[int]
+	err        error     // set before resultChan is closed if the request was rejected
+	deadline   time.Time // zero means no timeout
+	key        string    // client key for per-key rate limits
+	priority   Priority
+	tenant     string
+	trace      SpanContext  // the parent of its spans, if it is part of a trace
+	retry      *RetryPolicy // nil for the policy of the handler
+	journalID  uint64       // of its records in a Journal; 0 if not journaled
 }
 
+// The result channel has room for the answer, so a handler never waits for a
+// client that reads it late or not at all.
+func NewRequest(f func([]int) (int, error), args ...int) *Request {
+	return &Request{args: args, f: f, resultChan: make(chan Result[int], 1)}
+}
+
+// NewNamedRequest returns a request for the handler registered under name.
+func NewNamedRequest(name string, args ...int) *Request {
+	return &Request{name: name, args: args, resultChan: make(chan Result[int], 1)}
+}
+
+// String describes the request like a function call, e.g. sum(3, 4, 5).
+// Requests made with NewRequest are called func.
+func (req *Request) String() string {
+	name := req.name
+	if name == "" {
+		name = "func"
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('(')
+	for i, a := range req.args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.Itoa(a))
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// WithTimeout gives the request d from now to be answered. After that Result
+// reports ErrTimeout and the handler drops the answer.
+func (req *Request) WithTimeout(d time.Duration) *Request {
+	req.deadline = time.Now().Add(d)
+	return req
+}
+
+// WithKey names the client the request is made for, for per-key rate limits.
+func (req *Request) WithKey(key string) *Request {
+	req.key = key
+	return req
+}
+
+// WithPriority lets the request overtake requests of lower priority.
+func (req *Request) WithPriority(p Priority) *Request {
+	req.priority = p
+	return req
+}
+
+// WithRetry sets the retry policy of the request, overriding the one of its handler.
+func (req *Request) WithRetry(rp RetryPolicy) *Request {
+	req.retry = &rp
+	return req
+}
+
+// Result waits for the answer to the request.
+// A request rejected without being served reports the reason in Err.
+func (req *Request) Result() Result[int] {
+	var timeout <-chan time.Time
+	if !req.deadline.IsZero() {
+		t := time.NewTimer(time.Until(req.deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case res, ok := <-req.resultChan:
+		if !ok {
+			return Result[int]{Err: req.err}
+		}
+		return res
+	case <-timeout:
+		return Result[int]{Err: ErrTimeout}
+	}
+}
+
+// call wraps the request for ServeContext's pool. registry names its handler.
+func (req *Request) call(ctx context.Context, registry *Registry) *call[*Request, int] {
+	c := &call[*Request, int]{in: req, handler: registry.label(req)}
+	c.key, c.priority, c.retry, c.tenant = req.key, req.priority, req.retry, req.tenant
+	c.trace = req.trace
+	if !req.deadline.IsZero() {
+		ctx, c.cancel = context.WithDeadlineCause(ctx, req.deadline, ErrTimeout)
+	}
+	c.ctx = ctx
+	c.complete = func(res Result[int]) bool {
+		if ctx.Err() != nil { // the client stopped waiting
+			return false
+		}
+		req.resultChan <- res // never blocks, there is room for the answer
+		return true
+	}
+	return c
+}
+
+func (req *Request) reject(err error) {
+	req.err = err
+	close(req.resultChan) // wakes up the client waiting for the answer
+}
+
+// ErrServerClosed is reported by requests that were still queued when Serve
+// shut down, and by calls submitted to a closed Pool.
+var ErrServerClosed = errors.New("concurrency: server closed")
+
+// MaxOutstanding is the number of handlers a Server starts with, unless WithWorkers
+// says otherwise. Use Server.Resize to change it for a running server.
 var MaxOutstanding = 5
 
-func sum(a []int) (s int) {
+func sum(a []int) (s int, err error) {
 	for _, v := range a {
 		s += v
 	}
 	return
 }
 
-func handle(queue chan *Request) {
-	for req := range queue { // TODO seems to unwrap the chan obj
-		req.resultChan <- req.f(req.args)
+func init() {
+	Register("sum", Schema{Args: []string{"summands"}, Variadic: true, Result: "sum of the summands"},
+		func(ctx context.Context, args []int) (int, error) { return sum(args) })
+}
+
+// serve is what the handlers of a Server's pool run.
+func (req *Request) serve(ctx context.Context, registry *Registry) (int, error) {
+	if req.f == nil {
+		return registry.Call(ctx, req.name, req.args...)
 	}
+	return req.f(req.args)
 }
 
-func Serve(clientRequests chan *Request, quit chan bool) {
-	// Start handlers
-	for i := 0; i < MaxOutstanding; i++ {
-		go handle(clientRequests)
+// Server serves Requests with a Pool. The embedded pool can be used to watch
+// and tune it while it runs. Submit and SubmitAsync take requests without a channel.
+type Server struct {
+	*Pool[*Request, int]
+	registry *Registry
+	journal  *Journal
+	accepted sync.WaitGroup // requests accepted by Serve that are not done yet
+}
+
+// NewServer starts a pool of MaxOutstanding handlers, unless WithWorkers says
+// otherwise. Named requests are looked up in DefaultRegistry, or the registry
+// given by WithRegistry.
+func NewServer(opts ...Option) *Server {
+	opts = append([]Option{WithWorkers(MaxOutstanding), WithName("server")}, opts...)
+	cfg := newConfig(opts)
+	registry := cfg.registry
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	pool := NewPool(func(ctx context.Context, req *Request) (int, error) {
+		cfg.journal.started(req)
+		return req.serve(ctx, registry)
+	}, opts...)
+	pool.batchOf = registry.batchOf
+	pool.retryOf = registry.retryOf
+	return &Server{Pool: pool, registry: registry, journal: cfg.journal}
+}
+
+// Serve serves clientRequests until ctx is cancelled. Arriving requests wait in the
+// pool's queue, subject to its rate limits, and are handled in order of priority.
+// On cancellation requests already being handled are finished, requests still
+// waiting are rejected with ErrServerClosed. It closes the pool and returns
+// ctx.Err() once every handler has exited.
+//
+// Closing clientRequests stops Serve as well, but without rejecting anything:
+// it closes the pool and returns nil once every request it accepted has been
+// handled. If ctx is cancelled meanwhile, it shuts down as above.
+//
+// With WithJournal, the requests recovered by the journal are queued first,
+// and requests rejected with ErrServerClosed are redelivered next time.
+func (s *Server) Serve(ctx context.Context, clientRequests chan *Request) error {
+	// Accepted requests are finished even if ctx is cancelled meanwhile.
+	callCtx := context.WithoutCancel(ctx)
+
+	for _, req := range s.journal.redeliver() {
+		s.accept(ctx, callCtx, req)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			// Drain the queue without blocking; nobody is going to serve these anymore.
+			for {
+				select {
+				case req, ok := <-clientRequests:
+					if !ok {
+						return ctx.Err()
+					}
+					req.reject(ErrServerClosed)
+				default:
+					return ctx.Err()
+				}
+			}
+		case req, ok := <-clientRequests:
+			if !ok {
+				return s.closeWhenDone(ctx)
+			}
+			s.accept(ctx, callCtx, req)
+		}
+	}
+}
+
+// accept queues req, to be handled with callCtx unless Serve's ctx is done first.
+func (s *Server) accept(ctx, callCtx context.Context, req *Request) {
+	if err := s.journal.enqueued(req); err != nil {
+		req.reject(err)
+		return
+	}
+	s.accepted.Add(1)
+	c := req.call(callCtx, s.registry)
+	complete := c.complete
+	c.complete = func(res Result[int]) bool {
+		defer s.accepted.Done()
+		s.journal.completed(req)
+		return complete(res)
+	}
+	c.reject = func(err error) {
+		defer s.accepted.Done()
+		if c.cancel != nil {
+			c.cancel()
+		}
+		if ctx.Err() != nil {
+			err = ErrServerClosed
+		}
+		if err != ErrServerClosed { // otherwise it is redelivered
+			s.journal.completed(req)
+		}
+		req.reject(err)
+	}
+	s.admit(ctx, c)
+}
+
+// closeWhenDone waits for the accepted requests to be done, or for ctx to be
+// cancelled, and closes the pool.
+func (s *Server) closeWhenDone(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.accepted.Wait() // Close below rejects what is left, if it comes to that
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.Close()
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Submit handles req and waits for its result, or until ctx is done. Everything
+// set on req applies, like its timeout, key, priority, tenant and retry policy.
+// Unlike requests served by Serve, it is not journaled.
+func (s *Server) Submit(ctx context.Context, req *Request) (int, error) {
+	return s.SubmitAsync(ctx, req).Get(ctx)
+}
+
+// SubmitAsync queues req like Submit and returns a Future for its result,
+// which req.Result reports as well. It only blocks if a rate limit with
+// LimitBlock, or a full queue with OverflowBlock, says so.
+func (s *Server) SubmitAsync(ctx context.Context, req *Request) *Future[int] {
+	fut := newFuture[int]()
+	c := req.call(ctx, s.registry)
+	if c.trace.TraceID.IsZero() {
+		c.trace, _ = SpanFromContext(ctx)
+	}
+	stop := context.AfterFunc(c.ctx, func() {
+		fut.complete(Result[int]{Err: context.Cause(c.ctx)})
+	})
+	c.complete = func(res Result[int]) bool {
+		stop()
+		req.resultChan <- res // never blocks, there is room for the answer
+		return fut.complete(res)
+	}
+	c.reject = func(err error) {
+		s.finish(c, Result[int]{Err: err})
 	}
-	<-quit // Wait to be told to exit. TODO when does this happen
+	s.admit(c.ctx, c)
+	return fut
+}
+
+// ServeContext serves clientRequests with a new Server until ctx is cancelled.
+func ServeContext(ctx context.Context, clientRequests chan *Request, opts ...Option) error {
+	return NewServer(opts...).Serve(ctx, clientRequests)
+}
+
+func Serve(clientRequests chan *Request, quit chan bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ServeContext(ctx, clientRequests) }()
+
+	<-quit // Wait to be told to exit.
+	cancel()
+	<-done // and for the handlers to be gone.
 }
 
 /*
@@ -39,18 +328,29 @@ for a rate-limited, parallel, non-blocking RPC system, and there's not a mutex i
 */
 func MultiChannelExample() {
 	fmt.Println("=== Multi Channel Example ===")
-	request := &Request{[]int{3, 4, 5}, sum, make(chan int)}
-	request2 := &Request{[]int{1, -9}, sum, make(chan int)}
+	request := NewRequest(sum, 3, 4, 5)
+	request2 := NewNamedRequest("sum", 1, -9)                               // sum is registered in DefaultRegistry
+	request3 := NewRequest(func(a []int) (int, error) { return a[0], nil }) // index out of range
 
 	clientRequest := make(chan *Request)
+	quit := make(chan bool)
+	stopped := make(chan bool)
 
-	go Serve(clientRequest, make(chan bool))
+	go func() {
+		Serve(clientRequest, quit)
+		stopped <- true
+	}()
 
 	// Send request
 	clientRequest <- request
 	clientRequest <- request2
+	clientRequest <- request3
 
 	// Wait for response.
-	fmt.Printf("1. answer: %d\n", <-request.resultChan)
-	fmt.Printf("2. answer: %d\n", <-request2.resultChan)
+	fmt.Printf("1. answer: %d\n", request.Result().Value)
+	fmt.Printf("2. answer: %s = %d\n", request2, request2.Result().Value)
+	fmt.Printf("3. error: %v\n", request3.Result().Err) // the handler survived the panic
+
+	quit <- true
+	<-stopped // Serve returns only after all handlers are gone.
 }
@@ -0,0 +1,152 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// serveServer starts s serving a new request channel and returns the channel and
+// a function that cancels Serve and returns what it returned.
+func serveServer(t *testing.T, s *Server) (chan *Request, func() error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	requests := make(chan *Request)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, requests) }()
+	stop := func() error {
+		cancel()
+		return waitServe(t, done)
+	}
+	t.Cleanup(cancel)
+	return requests, stop
+}
+
+func waitServe(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+		return nil
+	}
+}
+
+func TestServe(t *testing.T) {
+	requests, stop := serveServer(t, NewServer(WithRegistry(NewRegistry())))
+	req := NewRequest(sum, 3, 4, 5)
+	requests <- req
+	if res := req.Result(); res.Value != 12 || res.Err != nil {
+		t.Errorf("sum(3, 4, 5) = %+v", res)
+	}
+	req = NewNamedRequest("nope")
+	requests <- req
+	if res := req.Result(); !errors.Is(res.Err, ErrUnknownHandler) {
+		t.Errorf("nope() = %+v; want ErrUnknownHandler", res)
+	}
+	if err := stop(); err != context.Canceled {
+		t.Errorf("Serve returned %v", err)
+	}
+}
+
+// Closing the request channel ends Serve after the accepted requests are handled.
+func TestServeClosedChannel(t *testing.T) {
+	s := NewServer(WithWorkers(1))
+	requests := make(chan *Request)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(context.Background(), requests) }()
+
+	release := make(chan bool)
+	blocked := NewRequest(func([]int) (int, error) { <-release; return 1, nil })
+	queued := NewRequest(sum, 1, 2)
+	requests <- blocked
+	requests <- queued
+	close(requests)
+	close(release)
+
+	if err := waitServe(t, done); err != nil {
+		t.Errorf("Serve returned %v", err)
+	}
+	if res := blocked.Result(); res.Value != 1 || res.Err != nil {
+		t.Errorf("blocked = %+v", res)
+	}
+	if res := queued.Result(); res.Value != 3 || res.Err != nil {
+		t.Errorf("queued = %+v", res)
+	}
+}
+
+// On cancellation the running request is finished and the waiting ones are rejected,
+// even if nobody reads the result of the running one until Serve has returned.
+func TestServeShutdown(t *testing.T) {
+	requests, stop := serveServer(t, NewServer(WithWorkers(1)))
+	started := make(chan bool)
+	running := NewRequest(func([]int) (int, error) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		return 1, nil
+	})
+	requests <- running
+	<-started
+	waiting := NewRequest(sum, 1)
+	requests <- waiting
+
+	if err := stop(); err != context.Canceled {
+		t.Errorf("Serve returned %v", err)
+	}
+	if res := running.Result(); res.Value != 1 || res.Err != nil {
+		t.Errorf("running = %+v", res)
+	}
+	if res := waiting.Result(); res.Err != ErrServerClosed {
+		t.Errorf("waiting = %+v; want ErrServerClosed", res)
+	}
+}
+
+func TestServeTimeoutAbandons(t *testing.T) {
+	s := NewServer(WithWorkers(1))
+	requests, stop := serveServer(t, s)
+	req := NewRequest(func([]int) (int, error) {
+		time.Sleep(30 * time.Millisecond)
+		return 1, nil
+	}).WithTimeout(5 * time.Millisecond)
+	requests <- req
+	if res := req.Result(); res.Err != ErrTimeout {
+		t.Errorf("Result() = %+v; want ErrTimeout", res)
+	}
+	stop()
+	if n := s.Abandoned(); n != 1 {
+		t.Errorf("Abandoned() = %d; want 1", n)
+	}
+}
+
+func TestServerSubmit(t *testing.T) {
+	s := NewServer(WithWorkers(1))
+	defer s.Close()
+	ctx := context.Background()
+	if v, err := s.Submit(ctx, NewNamedRequest("sum", 1, 2)); v != 3 || err != nil {
+		t.Errorf("Submit(sum(1, 2)) = %v, %v", v, err)
+	}
+	req := NewRequest(sum, 5)
+	if v, err := s.SubmitAsync(ctx, req).Get(ctx); v != 5 || err != nil {
+		t.Errorf("SubmitAsync(func(5)) = %v, %v", v, err)
+	}
+	if res := req.Result(); res.Value != 5 {
+		t.Errorf("Result() = %+v", res)
+	}
+
+	// The timeout of the request applies.
+	start := time.Now()
+	slow := NewRequest(func([]int) (int, error) { time.Sleep(200 * time.Millisecond); return 1, nil })
+	if _, err := s.Submit(ctx, slow.WithTimeout(10*time.Millisecond)); err != ErrTimeout {
+		t.Errorf("Submit of a slow request: %v; want ErrTimeout", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Submit took %v despite a timeout of 10ms", d)
+	}
+
+	// So does its handler name, in the metrics.
+	if _, ok := s.Stats().Handlers["sum"]; !ok {
+		t.Errorf("no metrics for handler sum: %v", s.Stats().Handlers)
+	}
+}
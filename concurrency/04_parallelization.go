@@ -30,16 +30,9 @@ var numCPU = runtime.GOMAXPROCS(0) // just queries with 0 value
 // They can complete in any order but it doesn't matter;
 // we just count the completion signals by draining the channel
 // after launching all the goroutines.
+// DefaultParallelism can change the split, see DoAllWith.
 func (v Vector) DoAll(u Vector) {
-	c := make(chan int, numCPU) // Buffering optional but sensible.
-	for i := 0; i < numCPU; i++ {
-		go v.DoSome(i*len(v)/numCPU, (i+1)*len(v)/numCPU, u, c)
-	}
-	// Drain the channel.
-	for i := 0; i < numCPU; i++ {
-		<-c // wait for one task to complete
-	}
-	// All done.
+	v.DoAllWith(u, DefaultParallelism)
 }
 
 func (v Vector) Op(f float64) float64 {
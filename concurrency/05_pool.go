@@ -0,0 +1,492 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// === GENERIC POOL ===
+/*
+The Serve example only knows about []int arguments and int results. With type
+parameters the same queue-of-requests design works for any payload: a Pool owns a
+queue and a number of handle goroutines that take calls off it, run the pool's
+function and hand the result back to whoever is waiting for it.
+*/
+
+// Result is the answer to a call: either a value or the reason there is none.
+type Result[T any] struct {
+	Value    T
+	Err      error
+	Attempts int // times the call ran, counting retries
+}
+
+// PanicError is the error delivered for a call whose function panicked.
+type PanicError struct {
+	Value interface{} // the value passed to panic
+	Stack []byte      // stack trace of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("concurrency: request panicked: %v", e.Value)
+}
+
+// Option configures a Pool, or the pool behind ServeContext.
+type Option func(*config)
+
+type config struct {
+	workers int
+
+	rate, keyRate   float64
+	burst, keyBurst int
+	limitMode       LimitMode
+
+	aging time.Duration
+
+	registry *Registry
+	name     string
+
+	maxWorkers   int
+	idleTimeout  time.Duration
+	scaleLatency time.Duration
+
+	retry *RetryPolicy
+
+	journal *Journal
+
+	queueLimit int
+	overflow   OverflowPolicy
+
+	weights map[string]float64
+
+	exporter SpanExporter
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{workers: numCPU, idleTimeout: DefaultIdleTimeout, name: "pool"}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.workers < 1 {
+		cfg.workers = 1
+	}
+	if cfg.maxWorkers < cfg.workers {
+		cfg.maxWorkers = cfg.workers
+	}
+	return cfg
+}
+
+// WithWorkers sets the number of handle goroutines.
+func WithWorkers(n int) Option {
+	return func(c *config) {
+		c.workers = n
+	}
+}
+
+// ErrTimeout is the error of a call that was not done within its timeout.
+var ErrTimeout = errors.New("concurrency: request timed out")
+
+// CallOption configures a single call to a Pool.
+type CallOption func(*callConfig)
+
+type callConfig struct {
+	timeout  time.Duration
+	key      string
+	priority Priority
+	handler  string // for metrics; the pool's name if empty
+	retry    *RetryPolicy
+	tenant   string
+}
+
+// WithTimeout limits the time a call may spend waiting in the queue and being
+// handled. When it elapses the caller gets ErrTimeout and a late result is discarded.
+func WithTimeout(d time.Duration) CallOption {
+	return func(c *callConfig) {
+		c.timeout = d
+	}
+}
+
+// call is a unit of work in the queue.
+type call[In, Out any] struct {
+	callConfig
+	ctx      context.Context
+	cancel   context.CancelFunc // releases the timeout, if any
+	in       In
+	complete func(Result[Out]) bool // delivers the result; false if nobody took it
+	reject   func(error)            // tells the caller the call won't be handled
+
+	spec  *Batch[In, Out]  // set for a batch, together with batch
+	batch []*call[In, Out] // the calls of a batch
+
+	queued   time.Time
+	attempts int
+	cost     time.Duration // charged to its tenant when it was taken off the queue
+	trace    SpanContext   // the parent of its spans
+	seq      uint64        // position in the queue
+	rank     int64         // priority, corrected for aging
+	index    int           // in the queue's heap
+}
+
+func newCall[In, Out any](ctx context.Context, in In, opts []CallOption) *call[In, Out] {
+	c := &call[In, Out]{in: in}
+	for _, opt := range opts {
+		opt(&c.callConfig)
+	}
+	c.trace, _ = SpanFromContext(ctx)
+	if c.timeout > 0 {
+		ctx, c.cancel = context.WithTimeoutCause(ctx, c.timeout, ErrTimeout)
+	}
+	c.ctx = ctx
+	return c
+}
+
+// Pool runs f on submitted inputs using a number of handle goroutines.
+type Pool[In, Out any] struct {
+	name      string
+	f         func(context.Context, In) (Out, error)
+	queue     *callQueue[In, Out]
+	limiter   *limiter                 // nil without rate limits
+	batchOf   func(In) *Batch[In, Out] // nil, or returning nil, for calls handled one by one
+	batcher   batcher[In, Out]
+	retry     *RetryPolicy          // for calls without one of their own
+	retryOf   func(In) *RetryPolicy // nil, or returning nil, for calls without a handler policy
+	exporter  SpanExporter          // nil without tracing
+	metrics   *metrics
+	abandoned atomic.Int64
+
+	minWorkers, maxWorkers    int
+	idleTimeout, scaleLatency time.Duration
+	idle                      atomic.Int64 // handlers waiting for work
+
+	mu       sync.Mutex // guards the fields below and adding to wg
+	closed   bool
+	running  int           // handlers
+	target   int           // handlers there should be
+	resized  chan struct{} // closed when handlers have to exit
+	quit     chan struct{}
+	retrying map[*call[In, Out]]*time.Timer // calls waiting for their next attempt
+	wg       sync.WaitGroup                 // handlers and calls waiting for a rate limit
+}
+
+// NewPool starts a pool running f. Without WithWorkers it runs one handler per CPU.
+func NewPool[In, Out any](f func(context.Context, In) (Out, error), opts ...Option) *Pool[In, Out] {
+	cfg := newConfig(opts)
+	p := &Pool[In, Out]{
+		name:         cfg.name,
+		f:            f,
+		metrics:      newMetrics(),
+		queue:        newCallQueue[In, Out](cfg),
+		limiter:      newLimiter(cfg),
+		retry:        cfg.retry,
+		exporter:     cfg.exporter,
+		minWorkers:   cfg.workers,
+		maxWorkers:   cfg.maxWorkers,
+		idleTimeout:  cfg.idleTimeout,
+		scaleLatency: cfg.scaleLatency,
+		target:       cfg.workers,
+		resized:      make(chan struct{}),
+		quit:         make(chan struct{}),
+		retrying:     make(map[*call[In, Out]]*time.Timer),
+	}
+	p.mu.Lock()
+	for p.running < p.target {
+		p.startWorker()
+	}
+	p.mu.Unlock()
+	return p
+}
+
+// Submit runs f(ctx, in) on one of the pool's handlers and waits for the result.
+func (p *Pool[In, Out]) Submit(ctx context.Context, in In, opts ...CallOption) (Out, error) {
+	return p.SubmitAsync(ctx, in, opts...).Get(ctx)
+}
+
+// SubmitAsync queues in and returns a Future for its result. It only blocks
+// if a rate limit with LimitBlock, or a full queue with OverflowBlock, says so.
+func (p *Pool[In, Out]) SubmitAsync(ctx context.Context, in In, opts ...CallOption) *Future[Out] {
+	fut := newFuture[Out]()
+	c := newCall[In, Out](ctx, in, opts)
+	// The future is given up as soon as the call's context is done,
+	// even if f is still running and ignores its context.
+	stop := context.AfterFunc(c.ctx, func() {
+		fut.complete(Result[Out]{Err: context.Cause(c.ctx)})
+	})
+	c.complete = func(res Result[Out]) bool {
+		stop()
+		return fut.complete(res)
+	}
+	c.reject = func(err error) {
+		p.finish(c, Result[Out]{Err: err})
+	}
+	p.admit(c.ctx, c)
+	return fut
+}
+
+// Abandoned reports how many results were discarded
+// because their caller had stopped waiting for them.
+func (p *Pool[In, Out]) Abandoned() int64 {
+	return p.abandoned.Load()
+}
+
+// admit queues c subject to the pool's rate limits, waiting no longer than ctx
+// allows. If c cannot be queued, c.reject is called with the reason; with
+// LimitQueue that may happen after admit has returned.
+func (p *Pool[In, Out]) admit(ctx context.Context, c *call[In, Out]) {
+	if p.limiter != nil {
+		switch p.limiter.mode {
+		case LimitReject:
+			if !p.limiter.allow(c.key) {
+				p.reject(c, ErrRateLimited)
+				return
+			}
+		case LimitBlock:
+			if err := p.limiter.wait(ctx, c.ctx, c.key, p.quit); err != nil {
+				p.reject(c, err)
+				return
+			}
+		case LimitQueue:
+			if !p.limiter.allow(c.key) {
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				if p.closed {
+					p.reject(c, ErrServerClosed)
+					return
+				}
+				p.wg.Add(1)
+				go func() {
+					defer p.wg.Done()
+					if err := p.limiter.wait(ctx, c.ctx, c.key, p.quit); err != nil {
+						p.reject(c, err)
+					} else {
+						p.enqueue(ctx, c)
+					}
+				}()
+				return
+			}
+		}
+	}
+	p.enqueue(ctx, c)
+}
+
+// enqueue puts c in the queue, or rejects it if the pool is closed. If the
+// queue is full and the overflow policy is OverflowBlock, it waits for room
+// no longer than ctx allows.
+func (p *Pool[In, Out]) enqueue(ctx context.Context, c *call[In, Out]) {
+	for {
+		p.mu.Lock()
+		if p.closed || p.batchOf != nil && p.batchOf(c.in) != nil || p.overflow() {
+			p.enqueueLocked(c)
+			if !p.queue.full() {
+				notify(p.queue.room) // pass it on to the next blocked caller
+			}
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+		if p.queue.overflow != OverflowBlock {
+			p.reject(c, ErrQueueFull)
+			return
+		}
+		select {
+		case <-p.queue.room:
+		case <-ctx.Done():
+			p.reject(c, context.Cause(ctx))
+			return
+		case <-c.ctx.Done():
+			p.reject(c, context.Cause(c.ctx))
+			return
+		case <-p.quit:
+			p.reject(c, ErrServerClosed)
+			return
+		}
+	}
+}
+
+// enqueueLocked puts c in the queue, whether there is room or not.
+// It must be called with p.mu held.
+func (p *Pool[In, Out]) enqueueLocked(c *call[In, Out]) {
+	if p.closed {
+		p.reject(c, ErrServerClosed)
+		return
+	}
+	if p.batchOf != nil {
+		if b := p.batchOf(c.in); b != nil {
+			p.enqueueBatched(b, c)
+			return
+		}
+	}
+	p.queue.push(c)
+	p.growIfBusy()
+}
+
+// Len reports the number of calls waiting for a handler, the depth of the queue.
+func (p *Pool[In, Out]) Len() int {
+	return p.queue.len()
+}
+
+// Close stops accepting calls and waits for the running ones to finish.
+// Calls still waiting in the queue or for a retry, and calls submitted
+// afterwards, fail with ErrServerClosed.
+func (p *Pool[In, Out]) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.quit)
+	}
+	retrying := p.stopRetries()
+	p.mu.Unlock()
+	p.wg.Wait()
+	calls := append(p.queue.drain(), p.batcher.drain()...)
+	for _, c := range append(calls, retrying...) {
+		if c.batch != nil {
+			for _, bc := range c.batch {
+				p.reject(bc, ErrServerClosed)
+			}
+			continue
+		}
+		p.reject(c, ErrServerClosed)
+	}
+}
+
+func (p *Pool[In, Out]) handle() {
+	defer p.wg.Done()
+	for !p.retire() {
+		c, ok := p.queue.pop()
+		if !ok {
+			if !p.idleWait() {
+				return
+			}
+			continue
+		}
+		p.growIfSlow(c)
+		start := time.Now()
+		if c.batch != nil {
+			p.runBatch(c)
+			p.queue.charge(c, time.Since(start))
+			continue
+		}
+		res := p.run(c)
+		p.queue.charge(c, time.Since(start))
+		p.settle(c, res)
+	}
+}
+
+// reject tells the caller of c that it won't run.
+func (p *Pool[In, Out]) reject(c *call[In, Out], err error) {
+	p.metrics.rejected.Add(1)
+	c.reject(err)
+}
+
+// finish delivers the result of c, or counts it as abandoned.
+func (p *Pool[In, Out]) finish(c *call[In, Out], res Result[Out]) {
+	res.Attempts = c.attempts
+	if !c.complete(res) {
+		p.abandoned.Add(1)
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// run calls f. A panic is turned into an error so that it kills neither
+// the handler nor the rest of the program.
+func (p *Pool[In, Out]) run(c *call[In, Out]) (res Result[Out]) {
+	if c.ctx.Err() != nil {
+		p.metrics.rejected.Add(1)
+		return Result[Out]{Err: context.Cause(c.ctx)} // the caller is gone already
+	}
+	c.attempts++
+	handler := c.handler
+	if handler == "" {
+		handler = p.name
+	}
+	p.metrics.start(handler, 1)
+	start := time.Now()
+	ctx, end := p.trace(c, start)
+	defer func() {
+		p.metrics.done(handler, 1, time.Since(start), res.Err)
+		end(res.Err)
+	}()
+	defer func() {
+		if err := recover(); err != nil {
+			res = Result[Out]{Err: &PanicError{Value: err, Stack: debug.Stack()}}
+		}
+	}()
+	v, err := p.f(ctx, c.in)
+	return Result[Out]{Value: v, Err: err}
+}
+
+// Future is the pending result of SubmitAsync.
+type Future[T any] struct {
+	once sync.Once
+	done chan struct{}
+	res  Result[T]
+}
+
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+// complete sets the result unless it is already set, and reports whether it did.
+func (f *Future[T]) complete(res Result[T]) (ok bool) {
+	f.once.Do(func() {
+		f.res = res
+		close(f.done)
+		ok = true
+	})
+	return ok
+}
+
+// Done is closed once the result is available.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for the result, or until ctx is done.
+func (f *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.res.Value, f.res.Err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Result is like Get, but returns the whole Result, including the number of attempts.
+func (f *Future[T]) Result(ctx context.Context) Result[T] {
+	select {
+	case <-f.done:
+		return f.res
+	case <-ctx.Done():
+		return Result[T]{Err: ctx.Err()}
+	}
+}
+
+func PoolExample() {
+	fmt.Println("=== Pool Example ===")
+	lengths := NewPool(func(ctx context.Context, s string) (int, error) {
+		return len(s), nil
+	}, WithWorkers(2))
+	defer lengths.Close()
+
+	ctx := context.Background()
+	n, err := lengths.Submit(ctx, "gopher")
+	fmt.Println("len(gopher):", n, err)
+
+	fut := lengths.SubmitAsync(ctx, "concurrency")
+	n, err = fut.Get(ctx)
+	fmt.Println("len(concurrency):", n, err)
+
+	slow := NewPool(func(ctx context.Context, d time.Duration) (time.Duration, error) {
+		time.Sleep(d) // ignores ctx, but the caller doesn't have to wait for it
+		return d, nil
+	})
+	defer slow.Close()
+	_, err = slow.Submit(ctx, 100*time.Millisecond, WithTimeout(10*time.Millisecond))
+	fmt.Println("slow call:", err)
+}
@@ -0,0 +1,224 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// === RATE LIMITING ===
+/*
+The number of handlers limits how many calls run at the same time, not how many
+are started per second. A token bucket does the latter: it holds up to burst
+tokens, refills at rate tokens per second, and every call has to take one.
+*/
+
+// ErrRateLimited is the error of a call rejected by a rate limit.
+var ErrRateLimited = errors.New("concurrency: rate limit exceeded")
+
+// LimitMode says what happens to a call that finds its rate limit exhausted.
+type LimitMode int
+
+const (
+	LimitBlock  LimitMode = iota // the caller waits until the call may be queued
+	LimitReject                  // the call fails with ErrRateLimited
+	LimitQueue                   // the call is accepted and waits inside the pool
+)
+
+// WithRateLimit limits the pool to rate calls per second, in bursts of up to burst calls.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *config) {
+		c.rate, c.burst = rate, burst
+	}
+}
+
+// WithKeyRateLimit gives every client key (see WithKey) its own limit of
+// rate calls per second, in bursts of up to burst calls.
+func WithKeyRateLimit(rate float64, burst int) Option {
+	return func(c *config) {
+		c.keyRate, c.keyBurst = rate, burst
+	}
+}
+
+// WithLimitMode chooses what happens when a rate limit is hit. The default is LimitBlock.
+func WithLimitMode(mode LimitMode) Option {
+	return func(c *config) {
+		c.limitMode = mode
+	}
+}
+
+// WithKey names the client a call is made for, for per-key rate limits.
+func WithKey(key string) CallOption {
+	return func(c *callConfig) {
+		c.key = key
+	}
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64 // negative while callers are waiting for tokens they reserved
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// refill must be called with b.mu held.
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// take takes a token if one is available right now.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes a token, possibly one that is yet to come,
+// and returns how long to wait until it is there.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund gives back a token that was taken but not used.
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	b.tokens++
+	b.mu.Unlock()
+}
+
+// full reports whether the bucket has not been used for long enough to be refilled completely.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// limiter applies the global and the per-key token buckets of a pool.
+type limiter struct {
+	mode     LimitMode
+	global   *tokenBucket // nil without WithRateLimit
+	keyRate  float64      // zero without WithKeyRateLimit
+	keyBurst int
+
+	mu   sync.Mutex
+	keys map[string]*tokenBucket
+}
+
+func newLimiter(cfg config) *limiter {
+	if cfg.rate <= 0 && cfg.keyRate <= 0 {
+		return nil
+	}
+	l := &limiter{mode: cfg.limitMode, keyRate: cfg.keyRate, keyBurst: cfg.keyBurst}
+	if cfg.rate > 0 {
+		l.global = newTokenBucket(cfg.rate, cfg.burst)
+	}
+	if cfg.keyRate > 0 {
+		l.keys = make(map[string]*tokenBucket)
+	}
+	return l
+}
+
+// buckets returns the buckets a call with the given key has to take tokens from.
+func (l *limiter) buckets(key string, now time.Time) []*tokenBucket {
+	var bs []*tokenBucket
+	if l.global != nil {
+		bs = append(bs, l.global)
+	}
+	if l.keys != nil {
+		l.mu.Lock()
+		b, ok := l.keys[key]
+		if !ok {
+			if len(l.keys) >= 1024 {
+				// Forget clients that have been quiet long enough to have a full
+				// bucket; a new one behaves exactly the same.
+				for k, kb := range l.keys {
+					if kb.full(now) {
+						delete(l.keys, k)
+					}
+				}
+			}
+			b = newTokenBucket(l.keyRate, l.keyBurst)
+			l.keys[key] = b
+		}
+		l.mu.Unlock()
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+// allow takes a token from every bucket of key, or from none of them.
+func (l *limiter) allow(key string) bool {
+	now := time.Now()
+	bs := l.buckets(key, now)
+	for i, b := range bs {
+		if !b.take(now) {
+			for _, taken := range bs[:i] {
+				taken.refund()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// wait blocks until key may make another call, or until ctx or callCtx is done,
+// or the pool quits.
+func (l *limiter) wait(ctx, callCtx context.Context, key string, quit <-chan struct{}) error {
+	now := time.Now()
+	bs := l.buckets(key, now)
+	var delay time.Duration
+	for _, b := range bs {
+		if d := b.reserve(now); d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	var err error
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	case <-callCtx.Done():
+		err = context.Cause(callCtx)
+	case <-quit:
+		err = ErrServerClosed
+	}
+	for _, b := range bs {
+		b.refund()
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 2)
+	b.last = now
+	if !b.take(now) || !b.take(now) {
+		t.Fatal("a full bucket of 2 doesn't give 2 tokens")
+	}
+	if b.take(now) {
+		t.Fatal("an empty bucket gives a token")
+	}
+	if !b.take(now.Add(100 * time.Millisecond)) {
+		t.Fatal("no token after 100ms at 10 per second")
+	}
+	if d := b.reserve(now.Add(100 * time.Millisecond)); d != 100*time.Millisecond {
+		t.Errorf("reserve waits %v; want 100ms", d)
+	}
+	b.refund()
+	if !b.full(now.Add(300 * time.Millisecond)) {
+		t.Error("bucket not full after 300ms")
+	}
+}
+
+func identity(_ context.Context, n int) (int, error) { return n, nil }
+
+func TestLimitReject(t *testing.T) {
+	p := NewPool(identity, WithRateLimit(1, 2), WithLimitMode(LimitReject))
+	defer p.Close()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := p.Submit(ctx, i); err != nil {
+			t.Fatalf("call %d of a burst of 2: %v", i, err)
+		}
+	}
+	if _, err := p.Submit(ctx, 2); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("call beyond the burst: %v; want ErrRateLimited", err)
+	}
+}
+
+func TestLimitBlock(t *testing.T) {
+	p := NewPool(identity, WithRateLimit(50, 1))
+	defer p.Close()
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := p.Submit(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first call takes the token there is, the others wait 20ms each.
+	if d := time.Since(start); d < 35*time.Millisecond {
+		t.Errorf("3 calls at 50 per second took %v", d)
+	}
+
+	// A caller that gives up stops waiting.
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	p.Submit(context.Background(), 0) // takes the token
+	if _, err := p.Submit(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("blocked call after its deadline: %v", err)
+	}
+}
+
+func TestLimitQueue(t *testing.T) {
+	p := NewPool(identity, WithRateLimit(50, 1), WithLimitMode(LimitQueue))
+	defer p.Close()
+	ctx := context.Background()
+	start := time.Now()
+	futs := make([]*Future[int], 3)
+	for i := range futs {
+		futs[i] = p.SubmitAsync(ctx, i)
+	}
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("SubmitAsync blocked for %v", d)
+	}
+	for i, fut := range futs {
+		if v, err := fut.Get(ctx); v != i || err != nil {
+			t.Errorf("call %d = %v, %v", i, v, err)
+		}
+	}
+	if d := time.Since(start); d < 35*time.Millisecond {
+		t.Errorf("3 calls at 50 per second took %v", d)
+	}
+}
+
+func TestKeyRateLimit(t *testing.T) {
+	p := NewPool(identity, WithKeyRateLimit(1, 1), WithLimitMode(LimitReject))
+	defer p.Close()
+	ctx := context.Background()
+	if _, err := p.Submit(ctx, 0, WithKey("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Submit(ctx, 0, WithKey("a")); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("second call of a: %v; want ErrRateLimited", err)
+	}
+	if _, err := p.Submit(ctx, 0, WithKey("b")); err != nil {
+		t.Errorf("first call of b: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package concurrency
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+	"time"
+)
+
+// === PRIORITIES ===
+/*
+A channel hands out its values first in, first out. To let urgent calls overtake
+the others the pool keeps its waiting calls in a heap ordered by priority instead.
+
+Strict priorities can starve: as long as high priority calls keep coming, a low
+priority one is never picked. So waiting calls age: every aging interval spent
+in the queue counts as one level of priority more. A call of priority p queued at
+time t is therefore ahead of another one exactly if p*aging - t is larger, which
+doesn't change while both are waiting, so the heap stays valid without reordering.
+*/
+
+// Priority orders the calls waiting in a Pool; higher priorities go first.
+// Any int is a valid priority, but priorities beyond some 292 years of aging
+// (about 9e9 levels with DefaultAging) count as equally extreme.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// DefaultAging is the time a call has to wait to move up one level of priority,
+// unless WithAging says otherwise.
+const DefaultAging = time.Second
+
+// WithPriority sets the priority of a call. The default is PriorityNormal.
+func WithPriority(p Priority) CallOption {
+	return func(c *callConfig) {
+		c.priority = p
+	}
+}
+
+// WithAging sets the time a waiting call needs to move up one level of priority.
+func WithAging(d time.Duration) Option {
+	return func(c *config) {
+		c.aging = d
+	}
+}
+
+// callQueue holds the calls waiting for a handler, in one heap per tenant.
+type callQueue[In, Out any] struct {
+	mu      sync.Mutex
+	tenants map[string]*tenantQueue[In, Out]
+	n       int // calls in all heaps
+	seq     uint64
+	aging   time.Duration
+	start   time.Time // enqueue times are measured from here, to keep ranks small
+
+	weights  map[string]float64
+	vclock   float64       // virtual time of the last tenant served
+	estimate time.Duration // of the time a call takes
+
+	limit    int // 0 for no limit
+	overflow OverflowPolicy
+
+	// ready has a value whenever there may be calls in the queue
+	// and no handler has been told about them yet.
+	ready chan struct{}
+	// room has a value whenever a call left a full queue and
+	// no blocked caller has been told about it yet.
+	room chan struct{}
+}
+
+func newCallQueue[In, Out any](cfg config) *callQueue[In, Out] {
+	aging := cfg.aging
+	if aging <= 0 {
+		aging = DefaultAging
+	}
+	return &callQueue[In, Out]{tenants: make(map[string]*tenantQueue[In, Out]), aging: aging, start: time.Now(),
+		weights: cfg.weights, estimate: time.Millisecond, limit: cfg.queueLimit, overflow: cfg.overflow,
+		ready: make(chan struct{}, 1), room: make(chan struct{}, 1)}
+}
+
+func (q *callQueue[In, Out]) push(c *call[In, Out]) {
+	q.mu.Lock()
+	q.seq++
+	c.seq = q.seq
+	c.queued = time.Now()
+	c.rank = rank(c.priority, q.aging, c.queued.Sub(q.start))
+	heap.Push(&q.tenant(c.tenant).calls, c)
+	q.n++
+	q.mu.Unlock()
+	q.signal()
+}
+
+// rank returns p*aging - since, saturated at the limits of int64 instead of
+// overflowing. aging is positive, since is not negative.
+func rank(p Priority, aging, since time.Duration) int64 {
+	r := int64(p) * int64(aging)
+	switch {
+	case int64(p) > math.MaxInt64/int64(aging):
+		r = math.MaxInt64
+	case int64(p) < math.MinInt64/int64(aging):
+		r = math.MinInt64
+	}
+	if r < math.MinInt64+int64(since) {
+		return math.MinInt64
+	}
+	return r - int64(since)
+}
+
+// pop takes the call that is first in line, if there is one.
+func (q *callQueue[In, Out]) pop() (*call[In, Out], bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t := q.next()
+	if t == nil {
+		return nil, false
+	}
+	c := heap.Pop(&t.calls).(*call[In, Out])
+	q.n--
+	q.dispatched(t, c)
+	if q.n > 0 {
+		q.signal() // pass it on to the next handler
+	}
+	if q.limit > 0 {
+		notify(q.room)
+	}
+	return c, true
+}
+
+// drain takes all calls out of the queue.
+func (q *callQueue[In, Out]) drain() []*call[In, Out] {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var calls []*call[In, Out]
+	for _, t := range q.tenants {
+		calls = append(calls, t.calls...)
+		t.calls = nil
+	}
+	q.n = 0
+	return calls
+}
+
+func (q *callQueue[In, Out]) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+func (q *callQueue[In, Out]) signal() {
+	notify(q.ready)
+}
+
+// notify puts a value into ch, which has a buffer of one, unless there is one already.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default: // somebody has been told already
+	}
+}
+
+// callHeap implements heap.Interface.
+type callHeap[In, Out any] []*call[In, Out]
+
+func (h callHeap[In, Out]) Len() int { return len(h) }
+
+func (h callHeap[In, Out]) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank > h[j].rank
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h callHeap[In, Out]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *callHeap[In, Out]) Push(x any) {
+	c := x.(*call[In, Out])
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *callHeap[In, Out]) Pop() any {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	old[n-1] = nil // don't keep the call alive
+	*h = old[:n-1]
+	c.index = -1
+	return c
+}
@@ -0,0 +1,111 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderPool is a pool of one handler that records the order in which it runs its
+// calls. Until release is called it is busy with a call that blocks, so that
+// calls submitted meanwhile wait in the queue.
+type orderPool struct {
+	*Pool[string, string]
+	release func()
+
+	mu    sync.Mutex
+	order []string
+}
+
+func newOrderPool(t *testing.T, run time.Duration, opts ...Option) *orderPool {
+	t.Helper()
+	started, gate := make(chan bool), make(chan bool)
+	op := &orderPool{release: sync.OnceFunc(func() { close(gate) })}
+	op.Pool = NewPool(func(_ context.Context, in string) (string, error) {
+		if in == "gate" {
+			close(started)
+			<-gate
+			return in, nil
+		}
+		time.Sleep(run)
+		op.mu.Lock()
+		op.order = append(op.order, in)
+		op.mu.Unlock()
+		return in, nil
+	}, append([]Option{WithWorkers(1)}, opts...)...)
+	op.SubmitAsync(context.Background(), "gate")
+	<-started
+	t.Cleanup(func() {
+		op.release()
+		op.Close()
+	})
+	return op
+}
+
+// wait releases the pool and waits for futs, and returns the order of the calls.
+func (op *orderPool) wait(futs []*Future[string]) []string {
+	op.release()
+	for _, fut := range futs {
+		fut.Get(context.Background())
+	}
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return append([]string(nil), op.order...)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPriorities(t *testing.T) {
+	op := newOrderPool(t, 0)
+	ctx := context.Background()
+	futs := []*Future[string]{
+		op.SubmitAsync(ctx, "low", WithPriority(PriorityLow)),
+		op.SubmitAsync(ctx, "normal"),
+		op.SubmitAsync(ctx, "high", WithPriority(PriorityHigh)),
+		op.SubmitAsync(ctx, "normal 2"),
+		op.SubmitAsync(ctx, "huge", WithPriority(1<<40)),
+	}
+	want := []string{"huge", "high", "normal", "normal 2", "low"}
+	if got := op.wait(futs); !equal(got, want) {
+		t.Errorf("order %q; want %q", got, want)
+	}
+}
+
+// A call that waited long enough overtakes calls of higher priority.
+func TestPriorityAging(t *testing.T) {
+	op := newOrderPool(t, 0, WithAging(10*time.Millisecond))
+	ctx := context.Background()
+	futs := []*Future[string]{op.SubmitAsync(ctx, "low", WithPriority(PriorityLow))}
+	time.Sleep(30 * time.Millisecond) // three levels up, to PriorityHigh+1
+	futs = append(futs,
+		op.SubmitAsync(ctx, "high", WithPriority(PriorityHigh)),
+		op.SubmitAsync(ctx, "normal"))
+	want := []string{"low", "high", "normal"}
+	if got := op.wait(futs); !equal(got, want) {
+		t.Errorf("order %q; want %q", got, want)
+	}
+}
+
+func TestRankSaturates(t *testing.T) {
+	normal := rank(PriorityNormal, time.Second, time.Minute)
+	if r := rank(1<<40, time.Second, time.Minute); r <= normal {
+		t.Errorf("rank of priority 1<<40 is %d, not above %d", r, normal)
+	}
+	if r := rank(-1<<40, time.Second, 0); r >= normal {
+		t.Errorf("rank of priority -1<<40 is %d, not below %d", r, normal)
+	}
+	if r := rank(Priority(-1<<62), time.Hour, time.Hour); r > normal {
+		t.Errorf("rank of priority -1<<62 overflowed to %d", r)
+	}
+}
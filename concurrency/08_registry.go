@@ -0,0 +1,144 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// === HANDLER REGISTRY ===
+/*
+A Request carrying a Go closure works fine within one program, but a closure can't
+be written to a log, sent over the network or counted in a metric. So handlers are
+registered under a name, and requests refer to them by that name.
+*/
+
+// ErrUnknownHandler is the error of a request naming a handler that isn't registered.
+var ErrUnknownHandler = errors.New("concurrency: unknown handler")
+
+// ErrInvalidArgs is the error of a request whose arguments don't fit the handler's schema.
+var ErrInvalidArgs = errors.New("concurrency: invalid arguments")
+
+// HandlerFunc is the signature of registered handlers.
+type HandlerFunc func(ctx context.Context, args []int) (int, error)
+
+// Schema describes what a handler takes and returns.
+type Schema struct {
+	Args     []string // names of the arguments, in order
+	Variadic bool     // the last argument may be given any number of times, including none
+	Result   string   // what the result means
+}
+
+func (s Schema) check(args []int) error {
+	if s.Variadic {
+		if min := len(s.Args) - 1; len(args) < min {
+			return fmt.Errorf("%w: want at least %d, got %d", ErrInvalidArgs, min, len(args))
+		}
+		return nil
+	}
+	if len(args) != len(s.Args) {
+		return fmt.Errorf("%w: want %d, got %d", ErrInvalidArgs, len(s.Args), len(args))
+	}
+	return nil
+}
+
+// Registry maps handler names to handlers.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]*handler
+}
+
+type handler struct {
+	name    string
+	schema  Schema
+	f       HandlerFunc
+	batch   *Batch[*Request, int]   // for handlers registered with RegisterBatch
+	retry   *RetryPolicy            // set by SetRetryPolicy
+	breaker atomic.Pointer[breaker] // set by SetBreaker
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]*handler)}
+}
+
+// DefaultRegistry is the registry used by ServeContext unless WithRegistry says otherwise.
+var DefaultRegistry = NewRegistry()
+
+// Register registers f under name in DefaultRegistry.
+func Register(name string, schema Schema, f HandlerFunc) {
+	DefaultRegistry.Register(name, schema, f)
+}
+
+// Register registers f under name. Like http.ServeMux, it panics if name is
+// empty or taken, or f is nil, as that is a programming error.
+func (r *Registry) Register(name string, schema Schema, f HandlerFunc) {
+	if name == "" {
+		panic("concurrency: empty handler name")
+	}
+	if f == nil {
+		panic("concurrency: nil handler " + name)
+	}
+	r.add(&handler{name: name, schema: schema, f: f})
+}
+
+func (r *Registry) add(h *handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handlers[h.name]; ok {
+		panic("concurrency: handler " + h.name + " registered twice")
+	}
+	r.handlers[h.name] = h
+}
+
+func (r *Registry) lookup(name string) (*handler, error) {
+	r.mu.RLock()
+	h, ok := r.handlers[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownHandler, name)
+	}
+	return h, nil
+}
+
+// Schema returns the schema of the handler registered under name.
+func (r *Registry) Schema(name string) (Schema, bool) {
+	h, err := r.lookup(name)
+	if err != nil {
+		return Schema{}, false
+	}
+	return h.schema, true
+}
+
+// Names returns the names of all registered handlers, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Call checks args against the schema of the handler registered under name and calls it.
+func (r *Registry) Call(ctx context.Context, name string, args ...int) (int, error) {
+	h, err := r.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	if err := h.schema.check(args); err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return guard(h.breaker.Load(), func() (int, error) { return h.f(ctx, args) })
+}
+
+// WithRegistry sets the registry ServeContext looks up request handlers in.
+func WithRegistry(r *Registry) Option {
+	return func(c *config) {
+		c.registry = r
+	}
+}
@@ -0,0 +1,151 @@
+package concurrency
+
+import (
+	"time"
+)
+
+// === AUTOSCALING ===
+/*
+A fixed number of handlers is either too many for a quiet pool or too few for a
+busy one. With WithMaxWorkers the pool starts another handler whenever calls are
+waiting and no handler is free to take them, or calls have been waiting longer
+than the WithScaleLatency target. Handlers that have been idle for the idle
+timeout exit again, down to the initial number given by WithWorkers.
+*/
+
+// DefaultIdleTimeout is the time an extra handler stays idle before it exits,
+// unless WithIdleTimeout says otherwise.
+const DefaultIdleTimeout = time.Minute
+
+// WithMaxWorkers lets the pool grow up to n handlers when it is busy.
+func WithMaxWorkers(n int) Option {
+	return func(c *config) {
+		c.maxWorkers = n
+	}
+}
+
+// WithIdleTimeout sets how long handlers above the initial number may stay idle.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+	}
+}
+
+// WithScaleLatency makes the pool grow when a call had to wait longer than d
+// for a handler, even if the queue looks short.
+func WithScaleLatency(d time.Duration) Option {
+	return func(c *config) {
+		c.scaleLatency = d
+	}
+}
+
+// Workers reports the number of running handlers.
+func (p *Pool[In, Out]) Workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
+// Resize sets the number of handlers to n, starting new ones or letting
+// surplus ones exit once they are done with their current call. The
+// autoscaler may change the number again later, within its limits.
+func (p *Pool[In, Out]) Resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.target = n
+	for p.running < p.target {
+		p.startWorker()
+	}
+	if p.running > p.target {
+		close(p.resized) // wake up idle handlers, so the surplus ones can exit
+		p.resized = make(chan struct{})
+	}
+}
+
+// startWorker must be called with p.mu held.
+func (p *Pool[In, Out]) startWorker() {
+	p.running++
+	p.wg.Add(1)
+	go p.handle()
+}
+
+// grow starts another handler if the autoscaler may. It must be called with p.mu held.
+func (p *Pool[In, Out]) grow() {
+	if !p.closed && p.running < p.maxWorkers {
+		p.target++
+		p.startWorker()
+	}
+}
+
+// growIfBusy grows the pool if calls are waiting with no idle handler to take them.
+// It must be called with p.mu held.
+func (p *Pool[In, Out]) growIfBusy() {
+	if p.running < p.maxWorkers && int64(p.queue.len()) > p.idle.Load() {
+		p.grow()
+	}
+}
+
+// growIfSlow grows the pool if c had to wait too long for a handler.
+func (p *Pool[In, Out]) growIfSlow(c *call[In, Out]) {
+	if p.scaleLatency > 0 && time.Since(c.queued) > p.scaleLatency {
+		p.mu.Lock()
+		p.grow()
+		p.mu.Unlock()
+	}
+}
+
+// retire reports whether the calling handler should exit, because
+// the pool is closed or has more handlers than it should.
+func (p *Pool[In, Out]) retire() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.running > p.target {
+		p.running--
+		return true
+	}
+	return false
+}
+
+// idleWait waits until there may be work for the calling handler. It reports
+// false if the handler should exit instead, because the pool is closed or
+// the handler has been idle for too long.
+func (p *Pool[In, Out]) idleWait() bool {
+	p.mu.Lock()
+	resized := p.resized
+	var timeout <-chan time.Time
+	if p.maxWorkers > p.minWorkers && p.target > p.minWorkers {
+		t := time.NewTimer(p.idleTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	p.mu.Unlock()
+
+	p.idle.Add(1)
+	defer p.idle.Add(-1)
+	select {
+	case <-p.queue.ready:
+		return true
+	case <-resized:
+		return true
+	case <-p.quit:
+		p.mu.Lock()
+		p.running--
+		p.mu.Unlock()
+		return false
+	case <-timeout:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.target > p.minWorkers && p.running >= p.target {
+			p.target--
+			p.running--
+			return false
+		}
+		return true
+	}
+}
@@ -0,0 +1,257 @@
+package concurrency
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// === METRICS ===
+
+// DefaultBuckets are the upper bounds of the latency histograms.
+var DefaultBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// WithName names the pool in its metrics. Calls of a Pool are counted under
+// this name as their handler, calls of a Server under the name of their request,
+// or UnknownHandler if no handler has that name.
+func WithName(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
+
+// UnknownHandler is the handler Server counts requests for unregistered names
+// under, so that clients can't add a handler to the metrics with every name they make up.
+const UnknownHandler = "unknown"
+
+// label returns the handler req is counted under.
+func (r *Registry) label(req *Request) string {
+	if req.name == "" {
+		return "func"
+	}
+	if _, err := r.lookup(req.name); err != nil {
+		return UnknownHandler
+	}
+	return req.name
+}
+
+// Histogram counts durations in buckets.
+type Histogram struct {
+	Buckets []time.Duration // upper bounds, ascending
+	Counts  []int64         // Counts[i] durations fell into Buckets[i]; the last one counts the rest
+	Count   int64
+	Sum     time.Duration
+}
+
+func newHistogram() Histogram {
+	return Histogram{Buckets: DefaultBuckets, Counts: make([]int64, len(DefaultBuckets)+1)}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i := sort.Search(len(h.Buckets), func(i int) bool { return d <= h.Buckets[i] })
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]int64(nil), h.Counts...)
+	return h
+}
+
+// Mean returns the average duration.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an upper bound for the q-quantile (0 < q <= 1), that is the
+// upper bound of the bucket it falls into. It returns -1 if that is beyond the last bucket.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.Count)))
+	var n int64
+	for i, c := range h.Counts[:len(h.Buckets)] {
+		n += c
+		if n >= rank {
+			return h.Buckets[i]
+		}
+	}
+	return -1
+}
+
+// HandlerStats are the numbers of one handler.
+type HandlerStats struct {
+	Started   int64 // calls that began to run
+	Completed int64 // calls that finished running, successfully or not
+	Failed    int64 // completed calls that returned an error
+	Panics    int64 // failed calls that panicked
+	Latency   Histogram
+}
+
+// Stats is a snapshot of the state of a pool.
+type Stats struct {
+	Name         string
+	Queued       int   // calls waiting for a handler
+	Active       int   // handlers running a call
+	Workers      int   // handlers
+	Rejected     int64 // calls that never ran: rate limited, closed, timed out in the queue
+	Abandoned    int64 // results nobody was waiting for anymore
+	HandlerStats       // totals over all handlers
+	Handlers     map[string]HandlerStats
+}
+
+type metrics struct {
+	active   atomic.Int64
+	rejected atomic.Int64
+
+	mu       sync.Mutex
+	total    HandlerStats
+	handlers map[string]*HandlerStats
+}
+
+func newMetrics() *metrics {
+	return &metrics{total: HandlerStats{Latency: newHistogram()}, handlers: make(map[string]*HandlerStats)}
+}
+
+// start records that n calls of handler began to run.
+func (m *metrics) start(handler string, n int) {
+	m.active.Add(1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.handlers[handler]
+	if !ok {
+		h = &HandlerStats{Latency: newHistogram()}
+		m.handlers[handler] = h
+	}
+	h.Started += int64(n)
+	m.total.Started += int64(n)
+}
+
+// done records that n calls of handler ended after d with err.
+func (m *metrics) done(handler string, n int, d time.Duration, err error) {
+	m.active.Add(-1)
+	var pe *PanicError
+	panicked := errors.As(err, &pe)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, h := range []*HandlerStats{m.handlers[handler], &m.total} {
+		h.Completed += int64(n)
+		for i := 0; i < n; i++ {
+			h.Latency.observe(d)
+		}
+		if err != nil {
+			h.Failed += int64(n)
+		}
+		if panicked {
+			h.Panics += int64(n)
+		}
+	}
+}
+
+// Stats returns a snapshot of the pool's numbers.
+func (p *Pool[In, Out]) Stats() Stats {
+	s := Stats{
+		Name:      p.name,
+		Queued:    p.queue.len(),
+		Active:    int(p.metrics.active.Load()),
+		Workers:   p.Workers(),
+		Rejected:  p.metrics.rejected.Load(),
+		Abandoned: p.abandoned.Load(),
+		Handlers:  make(map[string]HandlerStats),
+	}
+	p.metrics.mu.Lock()
+	defer p.metrics.mu.Unlock()
+	s.HandlerStats = p.metrics.total
+	s.Latency = s.Latency.clone()
+	for name, h := range p.metrics.handlers {
+		hs := *h
+		hs.Latency = hs.Latency.clone()
+		s.Handlers[name] = hs
+	}
+	return s
+}
+
+// WritePrometheus writes s in the Prometheus text exposition format.
+func (s Stats) WritePrometheus(w io.Writer) error {
+	b := new(strings.Builder)
+	pool := `pool="` + escapeLabel(s.Name) + `"`
+	gauge := func(name, help string, v int) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s{%s} %d\n", name, help, name, name, pool, v)
+	}
+	gauge("concurrency_pool_queued", "Calls waiting for a handler.", s.Queued)
+	gauge("concurrency_pool_active", "Handlers running a call.", s.Active)
+	gauge("concurrency_pool_workers", "Handlers.", s.Workers)
+	counter := func(name, help string, v int64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n%s{%s} %d\n", name, help, name, name, pool, v)
+	}
+	counter("concurrency_pool_rejected_total", "Calls that never ran.", s.Rejected)
+	counter("concurrency_pool_abandoned_total", "Results nobody was waiting for anymore.", s.Abandoned)
+
+	names := make([]string, 0, len(s.Handlers))
+	for name := range s.Handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	perHandler := func(name, help string, v func(HandlerStats) int64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, h := range names {
+			fmt.Fprintf(b, "%s{%s,handler=\"%s\"} %d\n", name, pool, escapeLabel(h), v(s.Handlers[h]))
+		}
+	}
+	perHandler("concurrency_pool_started_total", "Calls that began to run.",
+		func(h HandlerStats) int64 { return h.Started })
+	perHandler("concurrency_pool_completed_total", "Calls that finished running.",
+		func(h HandlerStats) int64 { return h.Completed })
+	perHandler("concurrency_pool_failed_total", "Calls that returned an error.",
+		func(h HandlerStats) int64 { return h.Failed })
+	perHandler("concurrency_pool_panics_total", "Calls that panicked.",
+		func(h HandlerStats) int64 { return h.Panics })
+
+	const latency = "concurrency_pool_duration_seconds"
+	fmt.Fprintf(b, "# HELP %s Time spent running calls.\n# TYPE %s histogram\n", latency, latency)
+	for _, name := range names {
+		h := s.Handlers[name].Latency
+		labels := pool + `,handler="` + escapeLabel(name) + `"`
+		var n int64
+		for i, le := range h.Buckets {
+			n += h.Counts[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%g\"} %d\n", latency, labels, le.Seconds(), n)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", latency, labels, h.Count)
+		fmt.Fprintf(b, "%s_sum{%s} %g\n", latency, labels, h.Sum.Seconds())
+		fmt.Fprintf(b, "%s_count{%s} %d\n", latency, labels, h.Count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// MetricsHandler returns an HTTP handler serving the pool's Stats in the
+// Prometheus text format, for example on a local port:
+//
+//	http.ListenAndServe("localhost:9090", pool.MetricsHandler())
+func (p *Pool[In, Out]) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		p.Stats().WritePrometheus(w)
+	})
+}
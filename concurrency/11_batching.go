@@ -0,0 +1,279 @@
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// === BATCHING ===
+/*
+For tiny calls like sum over three ints, queueing and handing back each result
+costs more than the call itself. A handler that can handle many inputs at once
+gets them in batches instead: the pool collects calls until it has Size of them
+or the first one has waited for Wait, queues them as one, and a handler runs the
+batch function once and hands each call its own result.
+*/
+
+// Batch describes how calls are batched.
+type Batch[In, Out any] struct {
+	// Func returns one result per input, in the same order, or an error for all of them.
+	Func func(ctx context.Context, in []In) ([]Out, error)
+	Size int           // a batch is queued as soon as it has this many calls
+	Wait time.Duration // or when its first call has waited this long
+
+	// Key, if set, coalesces calls: calls with the same key in one batch are
+	// computed only once, and all of them get that result.
+	Key func(In) string
+}
+
+// NewBatchPool starts a pool that runs calls in batches as described by b.
+func NewBatchPool[In, Out any](b Batch[In, Out], opts ...Option) *Pool[In, Out] {
+	p := NewPool(func(ctx context.Context, in In) (Out, error) {
+		return single(ctx, b.Func, in)
+	}, opts...)
+	p.batchOf = func(In) *Batch[In, Out] { return &b }
+	return p
+}
+
+// single calls a batch function with a batch of one.
+func single[In, Out any](ctx context.Context, f func(context.Context, []In) ([]Out, error), in In) (Out, error) {
+	var zero Out
+	outs, err := f(ctx, []In{in})
+	if err != nil {
+		return zero, err
+	}
+	if len(outs) != 1 {
+		return zero, fmt.Errorf("concurrency: batch of 1 returned %d results", len(outs))
+	}
+	return outs[0], nil
+}
+
+// ArgsKey is a Batch.Key for argument lists; lists with the same ints get the same key.
+func ArgsKey(args []int) string {
+	var b strings.Builder
+	for i, a := range args {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(a))
+	}
+	return b.String()
+}
+
+// RegisterBatch registers a handler that takes the arguments of many requests
+// at once. Requests naming it are batched by Servers as described by b.
+func (r *Registry) RegisterBatch(name string, schema Schema, b Batch[[]int, int]) {
+	if name == "" {
+		panic("concurrency: empty handler name")
+	}
+	if b.Func == nil {
+		panic("concurrency: nil batch handler " + name)
+	}
+	h := &handler{name: name, schema: schema}
+	h.f = func(ctx context.Context, args []int) (int, error) {
+		return single(ctx, b.Func, args)
+	}
+	h.batch = &Batch[*Request, int]{
+		Func: func(ctx context.Context, reqs []*Request) ([]int, error) {
+			args := make([][]int, len(reqs))
+			for i, req := range reqs {
+				args[i] = req.args
+			}
+			return guard(h.breaker.Load(), func() ([]int, error) { return b.Func(ctx, args) })
+		},
+		Size: b.Size,
+		Wait: b.Wait,
+	}
+	if b.Key != nil {
+		h.batch.Key = func(req *Request) string { return b.Key(req.args) }
+	}
+	r.add(h)
+}
+
+// batchOf returns how a request for a batch handler of r is batched,
+// or nil if it is handled on its own.
+func (r *Registry) batchOf(req *Request) *Batch[*Request, int] {
+	if req.f != nil {
+		return nil
+	}
+	h, err := r.lookup(req.name)
+	if err != nil || h.batch == nil || h.schema.check(req.args) != nil {
+		return nil // Registry.Call will report what is wrong
+	}
+	return h.batch
+}
+
+// batcher collects the calls of a pool into batches.
+type batcher[In, Out any] struct {
+	mu      sync.Mutex
+	pending map[*Batch[In, Out]]*pendingBatch[In, Out]
+}
+
+type pendingBatch[In, Out any] struct {
+	calls []*call[In, Out]
+	timer *time.Timer
+}
+
+// add adds c to the pending batch of b. It returns the calls of that batch
+// if it is full now; otherwise flush is called once it has waited long enough.
+func (bt *batcher[In, Out]) add(b *Batch[In, Out], c *call[In, Out], flush func([]*call[In, Out])) []*call[In, Out] {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if bt.pending == nil {
+		bt.pending = make(map[*Batch[In, Out]]*pendingBatch[In, Out])
+	}
+	pb, ok := bt.pending[b]
+	if !ok {
+		pb = &pendingBatch[In, Out]{}
+		bt.pending[b] = pb
+		pb.timer = time.AfterFunc(b.Wait, func() {
+			if calls := bt.take(b, pb); calls != nil {
+				flush(calls)
+			}
+		})
+	}
+	pb.calls = append(pb.calls, c)
+	if len(pb.calls) >= b.Size {
+		pb.timer.Stop()
+		delete(bt.pending, b)
+		return pb.calls
+	}
+	return nil
+}
+
+// take removes pb from the pending batches, unless it has been taken already.
+func (bt *batcher[In, Out]) take(b *Batch[In, Out], pb *pendingBatch[In, Out]) []*call[In, Out] {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if bt.pending[b] != pb {
+		return nil
+	}
+	delete(bt.pending, b)
+	return pb.calls
+}
+
+// drain removes all pending calls.
+func (bt *batcher[In, Out]) drain() []*call[In, Out] {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	var calls []*call[In, Out]
+	for b, pb := range bt.pending {
+		pb.timer.Stop()
+		calls = append(calls, pb.calls...)
+		delete(bt.pending, b)
+	}
+	return calls
+}
+
+// enqueueBatched adds c to its pending batch and queues the batch when it is
+// complete. It must be called with p.mu held.
+func (p *Pool[In, Out]) enqueueBatched(b *Batch[In, Out], c *call[In, Out]) {
+	flush := func(calls []*call[In, Out]) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.pushBatch(b, calls)
+	}
+	c.queued = time.Now()
+	if calls := p.batcher.add(b, c, flush); calls != nil {
+		p.pushBatch(b, calls)
+	}
+}
+
+// pushBatch queues calls as one batch. It must be called with p.mu held.
+func (p *Pool[In, Out]) pushBatch(b *Batch[In, Out], calls []*call[In, Out]) {
+	if p.closed {
+		for _, c := range calls {
+			p.reject(c, ErrServerClosed)
+		}
+		return
+	}
+	bc := &call[In, Out]{ctx: context.WithoutCancel(calls[0].ctx), spec: b, batch: calls}
+	bc.handler = calls[0].handler
+	bc.priority = calls[0].priority
+	bc.tenant = calls[0].tenant // who is charged for the batch
+	for _, c := range calls[1:] {
+		if c.priority > bc.priority {
+			bc.priority = c.priority
+		}
+	}
+	p.queue.push(bc)
+	p.growIfBusy()
+}
+
+// runBatch runs the batch bc and hands each of its calls its result.
+func (p *Pool[In, Out]) runBatch(bc *call[In, Out]) {
+	// Calls whose callers are gone are left out.
+	var calls []*call[In, Out]
+	for _, c := range bc.batch {
+		if c.ctx.Err() != nil {
+			p.metrics.rejected.Add(1)
+			p.finish(c, Result[Out]{Err: context.Cause(c.ctx)})
+			continue
+		}
+		c.attempts++
+		calls = append(calls, c)
+	}
+	if len(calls) == 0 {
+		return
+	}
+
+	// Coalesce calls with the same key into one input.
+	ins := make([]In, 0, len(calls))
+	index := make([]int, len(calls)) // of the input of every call
+	seen := make(map[string]int)
+	for i, c := range calls {
+		if bc.spec.Key != nil {
+			key := bc.spec.Key(c.in)
+			if j, ok := seen[key]; ok {
+				index[i] = j
+				continue
+			}
+			seen[key] = len(ins)
+		}
+		index[i] = len(ins)
+		ins = append(ins, c.in)
+	}
+
+	start := time.Now()
+	ends := make([]func(error), len(calls))
+	for i, c := range calls {
+		_, ends[i] = p.trace(c, start)
+	}
+	outs, err := p.callBatch(bc, ins, len(calls))
+	for i, c := range calls {
+		ends[i](err)
+		if err != nil {
+			p.settle(c, Result[Out]{Err: err})
+		} else {
+			p.finish(c, Result[Out]{Value: outs[index[i]]})
+		}
+	}
+}
+
+// callBatch calls the batch function of bc on ins, on behalf of n calls.
+func (p *Pool[In, Out]) callBatch(bc *call[In, Out], ins []In, n int) (outs []Out, err error) {
+	handler := bc.handler
+	if handler == "" {
+		handler = p.name
+	}
+	p.metrics.start(handler, n)
+	start := time.Now()
+	defer func() {
+		p.metrics.done(handler, n, time.Since(start), err)
+	}()
+	defer func() {
+		if v := recover(); v != nil {
+			outs, err = nil, &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	outs, err = bc.spec.Func(bc.ctx, ins)
+	if err == nil && len(outs) != len(ins) {
+		err = fmt.Errorf("concurrency: batch of %d returned %d results", len(ins), len(outs))
+	}
+	return outs, err
+}
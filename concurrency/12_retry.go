@@ -0,0 +1,195 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// === RETRIES ===
+/*
+Some failures go away by themselves. A RetryPolicy lets the pool try a failed
+call again after a while, waiting twice as long after every attempt. The
+waiting happens on a timer, not in a handler, so the other calls don't notice.
+*/
+
+// RetryPolicy says whether and when failed calls are tried again.
+type RetryPolicy struct {
+	MaxAttempts int           // including the first one; less than 2 means no retries
+	Backoff     time.Duration // before the first retry; doubled for every further one
+	MaxBackoff  time.Duration // upper bound for the backoff, if positive
+	Jitter      float64       // between 0 and 1: the fraction of the backoff that is random
+
+	// Retryable decides which errors are worth another attempt. If it is nil,
+	// all are, except invalid requests, errors of the caller's context and
+	// ErrCircuitOpen, which fails again at once until the breaker cools down.
+	Retryable func(error) bool
+
+	// Budget, if set, is shared with other policies and limits their retries together.
+	Budget *RetryBudget
+}
+
+func (rp *RetryPolicy) retryable(err error) bool {
+	if rp.Retryable != nil {
+		return rp.Retryable(err)
+	}
+	return !errors.Is(err, ErrUnknownHandler) && !errors.Is(err, ErrInvalidArgs) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+		!errors.Is(err, ErrTimeout) && !errors.Is(err, ErrCircuitOpen)
+}
+
+// backoff returns the time to wait after the given failed attempt.
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	d := rp.Backoff
+	for i := 1; i < attempt && (rp.MaxBackoff <= 0 || d < rp.MaxBackoff) && d <= math.MaxInt64/2; i++ {
+		d *= 2 // stops before it overflows, after some 146 years
+	}
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	if rp.Jitter > 0 {
+		d -= time.Duration(rp.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// RetryBudget limits retries to a fraction of the calls, so that a failing
+// handler doesn't get even more load from retries. Every first attempt earns
+// ratio retries, up to max; every retry spends one.
+type RetryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+// NewRetryBudget returns a budget allowing retries for ratio of the calls,
+// and for max calls to start with.
+func NewRetryBudget(ratio float64, max int) *RetryBudget {
+	return &RetryBudget{ratio: ratio, max: float64(max), tokens: float64(max)}
+}
+
+func (b *RetryBudget) earn() {
+	b.mu.Lock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.mu.Unlock()
+}
+
+func (b *RetryBudget) spend() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// WithRetryPolicy sets the retry policy of all calls of the pool.
+func WithRetryPolicy(rp RetryPolicy) Option {
+	return func(c *config) {
+		c.retry = &rp
+	}
+}
+
+// WithRetry sets the retry policy of a call, overriding the one of its handler and pool.
+func WithRetry(rp RetryPolicy) CallOption {
+	return func(c *callConfig) {
+		c.retry = &rp
+	}
+}
+
+// SetRetryPolicy sets the retry policy of the handler registered under name
+// for requests that don't have one of their own.
+func (r *Registry) SetRetryPolicy(name string, rp RetryPolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.handlers[name]
+	if !ok {
+		return ErrUnknownHandler
+	}
+	h.retry = &rp
+	return nil
+}
+
+// retryOf returns the retry policy of the handler of req, if there is one.
+func (r *Registry) retryOf(req *Request) *RetryPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.handlers[req.name]; ok && req.f == nil {
+		return h.retry
+	}
+	return nil
+}
+
+// policy returns the retry policy of c, or nil.
+func (p *Pool[In, Out]) policy(c *call[In, Out]) *RetryPolicy {
+	if c.retry != nil {
+		return c.retry
+	}
+	if p.retryOf != nil {
+		if rp := p.retryOf(c.in); rp != nil {
+			return rp
+		}
+	}
+	return p.retry
+}
+
+// settle finishes c with res, unless it failed in a way its retry policy
+// wants to retry. Then it queues c again after the backoff.
+func (p *Pool[In, Out]) settle(c *call[In, Out], res Result[Out]) {
+	rp := p.policy(c)
+	if rp == nil {
+		p.finish(c, res)
+		return
+	}
+	if c.attempts == 1 && rp.Budget != nil {
+		rp.Budget.earn()
+	}
+	if res.Err == nil || c.attempts >= rp.MaxAttempts || !rp.retryable(res.Err) || c.ctx.Err() != nil {
+		p.finish(c, res)
+		return
+	}
+	wait := rp.backoff(c.attempts)
+	if deadline, ok := c.ctx.Deadline(); ok && time.Until(deadline) < wait {
+		p.finish(c, res) // no time for another attempt
+		return
+	}
+	if rp.Budget != nil && !rp.Budget.spend() {
+		p.finish(c, res)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		p.finish(c, res)
+		return
+	}
+	p.retrying[c] = time.AfterFunc(wait, func() {
+		p.mu.Lock()
+		_, ok := p.retrying[c]
+		delete(p.retrying, c)
+		p.mu.Unlock()
+		if ok { // otherwise Close took care of it
+			p.enqueue(c.ctx, c)
+		}
+	})
+}
+
+// stopRetries takes the calls waiting to be retried. It must be called with p.mu held.
+func (p *Pool[In, Out]) stopRetries() []*call[In, Out] {
+	var calls []*call[In, Out]
+	for c, t := range p.retrying {
+		t.Stop()
+		calls = append(calls, c)
+		delete(p.retrying, c)
+	}
+	return calls
+}
@@ -0,0 +1,221 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// === CIRCUIT BREAKERS ===
+/*
+A handler whose backend is down fails every call, and usually slowly. Retrying
+makes that worse. A circuit breaker watches the calls of one handler: after a
+number of failures in a row it opens, and calls fail at once with ErrCircuitOpen
+instead of reaching the handler. After a cool-down it lets a few trial calls
+through (half-open); if they succeed it closes again, if one fails it opens again.
+*/
+
+// ErrCircuitOpen is the error of a call to a handler whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("concurrency: circuit open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // calls go through
+	BreakerOpen                         // calls fail with ErrCircuitOpen
+	BreakerHalfOpen                     // a few trial calls go through
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// Logger is what breakers log state changes to. *log.Logger and
+// types embedding one, like Job, are Loggers.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Breaker configures the circuit breaker of a handler.
+type Breaker struct {
+	Failures   int           // failures in a row that open the circuit; 5 if not positive
+	CoolDown   time.Duration // time the circuit stays open; 10s if not positive
+	TrialCalls int           // calls let through, and successes needed, to close it again; 1 if not positive
+
+	// OnStateChange, if set, is called after every state change.
+	OnStateChange func(handler string, from, to BreakerState)
+	// Logger, if set, gets a line for every state change.
+	Logger Logger
+}
+
+// breaker is the circuit breaker of one handler. Errors from the caller's
+// context, cancellation in particular, say nothing about the handler and
+// don't count as failures.
+type breaker struct {
+	Breaker
+	name string
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int // in a row, while closed
+	opened    time.Time
+	trials    int    // trial calls running, while half-open
+	successes int    // of trial calls
+	gen       uint64 // counts the changes of state
+}
+
+func newBreaker(name string, b Breaker) *breaker {
+	if b.Failures < 1 {
+		b.Failures = 5
+	}
+	if b.CoolDown <= 0 {
+		b.CoolDown = 10 * time.Second
+	}
+	if b.TrialCalls < 1 {
+		b.TrialCalls = 1
+	}
+	return &breaker{Breaker: b, name: name}
+}
+
+// allow reports whether a call may go through, and counts it as a trial
+// call if the circuit is half-open. The call's outcome is to be recorded
+// with the generation it returns.
+func (b *breaker) allow() (gen uint64, ok bool) {
+	b.mu.Lock()
+	from := b.state
+	if b.state == BreakerOpen && time.Since(b.opened) >= b.CoolDown {
+		b.state, b.trials, b.successes = BreakerHalfOpen, 0, 0
+		b.gen++
+	}
+	ok = true
+	switch b.state {
+	case BreakerOpen:
+		ok = false
+	case BreakerHalfOpen:
+		ok = b.trials < b.TrialCalls
+		if ok {
+			b.trials++
+		}
+	}
+	to, gen := b.state, b.gen
+	b.mu.Unlock()
+	b.changed(from, to)
+	return gen, ok
+}
+
+// record counts the outcome of a call that allow let through in generation gen.
+// Calls from before the last change of state are ignored: a call that started
+// while the circuit was closed says nothing about a half-open one, for example.
+func (b *breaker) record(gen uint64, err error) {
+	failed := err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	b.mu.Lock()
+	if gen != b.gen {
+		b.mu.Unlock()
+		return
+	}
+	from := b.state
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.failures = 0
+		} else if b.failures++; b.failures >= b.Failures {
+			b.open()
+		}
+	case BreakerHalfOpen:
+		if b.trials > 0 {
+			b.trials--
+		}
+		if failed {
+			b.open()
+		} else if err == nil {
+			if b.successes++; b.successes >= b.TrialCalls {
+				b.state, b.failures = BreakerClosed, 0
+				b.gen++
+			}
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+}
+
+// open must be called with b.mu held.
+func (b *breaker) open() {
+	b.state, b.opened, b.failures = BreakerOpen, time.Now(), 0
+	b.gen++
+}
+
+func (b *breaker) changed(from, to BreakerState) {
+	if from == to {
+		return
+	}
+	if b.Logger != nil {
+		b.Logger.Printf("concurrency: circuit of handler %q: %v -> %v", b.name, from, to)
+	}
+	if b.OnStateChange != nil {
+		b.OnStateChange(b.name, from, to)
+	}
+}
+
+// guard calls f if the breaker b, which may be nil, lets it, and records the outcome.
+// A panic in f counts as a failure.
+func guard[T any](b *breaker, f func() (T, error)) (v T, err error) {
+	if b == nil {
+		return f()
+	}
+	gen, ok := b.allow()
+	if !ok {
+		return v, fmt.Errorf("%w %q", ErrCircuitOpen, b.name)
+	}
+	panicked := true
+	defer func() {
+		if panicked {
+			err = errors.New("panic")
+		}
+		b.record(gen, err)
+	}()
+	v, err = f()
+	panicked = false
+	return v, err
+}
+
+// SetBreaker puts a circuit breaker configured by b in front of the handler
+// registered under name, replacing the one it had, if any.
+func (r *Registry) SetBreaker(name string, b Breaker) error {
+	h, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	h.breaker.Store(newBreaker(name, b))
+	return nil
+}
+
+// BreakerState returns the state of the circuit breaker of the handler
+// registered under name. It reports false if there is none.
+func (r *Registry) BreakerState(name string) (BreakerState, bool) {
+	h, err := r.lookup(name)
+	if err != nil {
+		return 0, false
+	}
+	b := h.breaker.Load()
+	if b == nil {
+		return 0, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.opened) >= b.CoolDown {
+		return BreakerHalfOpen, true // as soon as the next call comes
+	}
+	return b.state, true
+}
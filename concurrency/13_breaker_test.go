@@ -0,0 +1,73 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	r := NewRegistry()
+	fail := true
+	r.Register("flaky", Schema{}, func(context.Context, []int) (int, error) {
+		if fail {
+			return 0, errors.New("boom")
+		}
+		return 1, nil
+	})
+	var changes []BreakerState
+	err := r.SetBreaker("flaky", Breaker{Failures: 2, CoolDown: 10 * time.Millisecond,
+		OnStateChange: func(_ string, _, to BreakerState) { changes = append(changes, to) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	r.Call(ctx, "flaky")
+	r.Call(ctx, "flaky")
+	if _, err := r.Call(ctx, "flaky"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("third call: %v; want ErrCircuitOpen", err)
+	}
+	time.Sleep(15 * time.Millisecond)
+	fail = false
+	if v, err := r.Call(ctx, "flaky"); v != 1 || err != nil {
+		t.Fatalf("trial call = %v, %v", v, err)
+	}
+	if state, _ := r.BreakerState("flaky"); state != BreakerClosed {
+		t.Errorf("state after a good trial call: %v", state)
+	}
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("state changes %v; want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("state changes %v; want %v", changes, want)
+		}
+	}
+}
+
+// A call that started before the circuit opened doesn't count as a trial call.
+func TestBreakerIgnoresStaleCalls(t *testing.T) {
+	b := newBreaker("h", Breaker{Failures: 1, CoolDown: 10 * time.Millisecond})
+	slow, _ := b.allow()
+	failing, _ := b.allow()
+	b.record(failing, errors.New("boom"))
+	time.Sleep(15 * time.Millisecond)
+
+	trial, ok := b.allow()
+	if !ok || b.state != BreakerHalfOpen {
+		t.Fatalf("no trial call after the cool-down: %v", b.state)
+	}
+	b.record(slow, nil)
+	if b.state != BreakerHalfOpen {
+		t.Fatalf("a call from before the circuit opened closed it")
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("a second trial call was let through")
+	}
+	b.record(trial, nil)
+	if b.state != BreakerClosed {
+		t.Fatalf("state after a good trial call: %v", b.state)
+	}
+}
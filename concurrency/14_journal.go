@@ -0,0 +1,315 @@
+package concurrency
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// === DURABLE QUEUE ===
+/*
+Requests waiting in a channel or in the pool's queue are lost when the process
+dies. A Journal writes every named request a Server accepts to an append-only
+file, followed by a record when it starts and one when it completes. When the
+journal is opened again, the requests without a completed record are read back
+and the Server queues them before any new one: every request is handled at
+least once, and may be handled twice if the process died while it ran.
+
+Requests made with NewRequest carry a Go function, which can't be written to a
+file, so they are not journaled. Records are written through to the operating
+system before a request is queued, which survives a crash of the process but
+not of the machine.
+*/
+
+// DefaultCompactInterval is how often a Journal compacts its file,
+// unless OpenJournal is told otherwise.
+const DefaultCompactInterval = time.Minute
+
+type journalOp string
+
+const (
+	opEnqueued  journalOp = "enqueued"
+	opStarted   journalOp = "started"
+	opCompleted journalOp = "completed"
+)
+
+// journalRecord is one line of a journal file.
+type journalRecord struct {
+	Op       journalOp `json:"op"`
+	ID       uint64    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Args     []int     `json:"args,omitempty"`
+	Key      string    `json:"key,omitempty"`
+	Priority Priority  `json:"priority,omitempty"`
+	Tenant   string    `json:"tenant,omitempty"`
+	Deadline time.Time `json:"deadline,omitzero"`
+}
+
+// Journal is an append-only log of the requests of a Server, see WithJournal.
+type Journal struct {
+	path string
+
+	mu        sync.Mutex
+	f         *os.File
+	nextID    uint64
+	pending   map[uint64]journalRecord // enqueued, not completed
+	records   int                      // in the file
+	recovered []*Request               // not handed to a Server yet
+	err       error                    // the first failed write
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+// OpenJournal opens the journal file at path, creating it if necessary, and
+// reads back the requests that were not completed. The file is compacted
+// every interval, or every DefaultCompactInterval if interval is not positive.
+// It fails if a line other than the last one, which a crash may have cut off,
+// is not a valid record: requests could be lost otherwise.
+func OpenJournal(path string, interval time.Duration) (*Journal, error) {
+	j := &Journal{path: path, pending: make(map[uint64]journalRecord), quit: make(chan struct{}), done: make(chan struct{})}
+	if err := j.read(); err != nil {
+		return nil, err
+	}
+	for _, rec := range j.sorted() {
+		req := NewNamedRequest(rec.Name, rec.Args...).WithKey(rec.Key).WithPriority(rec.Priority).WithTenant(rec.Tenant)
+		req.deadline = rec.Deadline
+		req.journalID = rec.ID
+		j.recovered = append(j.recovered, req)
+	}
+	if err := j.compact(); err != nil { // also drops a record torn by a crash
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = DefaultCompactInterval
+	}
+	go j.compactEvery(interval)
+	return j, nil
+}
+
+func (j *Journal) read() error {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	var torn error // of the line before, which is fine if it was the last one
+	for line := 1; s.Scan(); line++ {
+		if torn != nil {
+			return torn
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			torn = fmt.Errorf("concurrency: journal %s, line %d: %w", j.path, line, err)
+			continue // unless it is the last line, cut off by a crash
+		}
+		switch rec.Op {
+		case opEnqueued:
+			j.pending[rec.ID] = rec
+		case opCompleted:
+			delete(j.pending, rec.ID)
+		}
+		if rec.ID > j.nextID {
+			j.nextID = rec.ID
+		}
+	}
+	return s.Err()
+}
+
+// sorted returns the pending records in the order they were enqueued.
+func (j *Journal) sorted() []journalRecord {
+	recs := make([]journalRecord, 0, len(j.pending))
+	for _, rec := range j.pending {
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(a, b int) bool { return recs[a].ID < recs[b].ID })
+	return recs
+}
+
+// Recovered returns the requests read back by OpenJournal. The Server using
+// the journal queues them when it starts serving; their results can be
+// waited for as usual, but need not be.
+func (j *Journal) Recovered() []*Request {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]*Request(nil), j.recovered...)
+}
+
+// redeliver returns the recovered requests, once.
+func (j *Journal) redeliver() []*Request {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	reqs := j.recovered
+	j.recovered = nil
+	return reqs
+}
+
+// enqueued records that req was accepted. Requests that can't be journaled are left alone.
+func (j *Journal) enqueued(req *Request) error {
+	if j == nil || req.f != nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if req.journalID != 0 {
+		return nil // recovered, and still in the file
+	}
+	j.nextID++
+	rec := journalRecord{Op: opEnqueued, ID: j.nextID, Name: req.name, Args: req.args,
+		Key: req.key, Priority: req.priority, Tenant: req.tenant, Deadline: req.deadline}
+	if err := j.write(rec); err != nil {
+		return err
+	}
+	req.journalID = rec.ID
+	j.pending[rec.ID] = rec
+	return nil
+}
+
+// started records that a handler took on req.
+func (j *Journal) started(req *Request) {
+	if j == nil || req.journalID == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.write(journalRecord{Op: opStarted, ID: req.journalID})
+}
+
+// completed records that req is done with, so it won't be redelivered.
+func (j *Journal) completed(req *Request) {
+	if j == nil || req.journalID == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.pending[req.journalID]; !ok {
+		return
+	}
+	if j.write(journalRecord{Op: opCompleted, ID: req.journalID}) == nil {
+		delete(j.pending, req.journalID)
+	}
+}
+
+// write appends rec to the file. It must be called with j.mu held.
+func (j *Journal) write(rec journalRecord) error {
+	if j.f == nil {
+		return fmt.Errorf("concurrency: journal %s is closed", j.path)
+	}
+	b, err := json.Marshal(rec)
+	if err == nil {
+		_, err = j.f.Write(append(b, '\n'))
+	}
+	if err != nil {
+		err = fmt.Errorf("concurrency: journal %s: %w", j.path, err)
+		if j.err == nil {
+			j.err = err
+		}
+		return err
+	}
+	j.records++
+	return nil
+}
+
+// Compact rewrites the file with only the requests that are not completed.
+func (j *Journal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compact()
+}
+
+// compact must be called with j.mu held.
+func (j *Journal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	recs := j.sorted()
+	for _, rec := range recs {
+		if err = enc.Encode(rec); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("concurrency: compacting journal %s: %w", j.path, err)
+	}
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0)
+	j.records = len(recs)
+	return err
+}
+
+func (j *Journal) compactEvery(interval time.Duration) {
+	defer close(j.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			j.mu.Lock()
+			if j.f != nil && j.records > len(j.pending) {
+				j.compact()
+			}
+			j.mu.Unlock()
+		case <-j.quit:
+			return
+		}
+	}
+}
+
+// Close compacts and closes the file. It returns the first error
+// writing to it, if there was one.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.f == nil {
+		j.mu.Unlock()
+		return j.err
+	}
+	close(j.quit)
+	err := j.compact()
+	j.f.Close()
+	j.f = nil
+	if j.err != nil {
+		err = j.err
+	}
+	j.mu.Unlock()
+	<-j.done
+	return err
+}
+
+// WithJournal makes a Server write the requests it accepts to j,
+// and queue the requests j recovered when it starts serving.
+func WithJournal(j *Journal) Option {
+	return func(c *config) {
+		c.journal = j
+	}
+}
@@ -0,0 +1,118 @@
+package concurrency
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openJournal(t *testing.T, path string) *Journal {
+	t.Helper()
+	j, err := OpenJournal(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestJournalRecovers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j := openJournal(t, path)
+	done := NewNamedRequest("sum", 1)
+	pending := NewNamedRequest("sum", 2, 3).WithKey("k").WithPriority(PriorityHigh).WithTenant("t")
+	for _, req := range []*Request{done, pending} {
+		if err := j.enqueued(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.started(done)
+	j.started(pending)
+	j.completed(done)
+	j.Close()
+
+	j = openJournal(t, path)
+	defer j.Close()
+	reqs := j.Recovered()
+	if len(reqs) != 1 {
+		t.Fatalf("recovered %v; want only %v", reqs, pending)
+	}
+	req := reqs[0]
+	if req.String() != "sum(2, 3)" || req.key != "k" || req.priority != PriorityHigh || req.tenant != "t" {
+		t.Errorf("recovered %v, key %q, priority %v, tenant %q", req, req.key, req.priority, req.tenant)
+	}
+}
+
+// Requests still queued when a Server shuts down are served by the next one.
+func TestJournalRedelivers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	registry := NewRegistry()
+	started, release := make(chan bool), make(chan bool)
+	registry.Register("block", Schema{}, func(context.Context, []int) (int, error) {
+		started <- true
+		<-release
+		return 0, nil
+	})
+	registry.Register("sum", Schema{Args: []string{"summands"}, Variadic: true},
+		func(_ context.Context, args []int) (int, error) { return sum(args) })
+
+	j := openJournal(t, path)
+	requests, stop := serveServer(t, NewServer(WithWorkers(1), WithRegistry(registry), WithJournal(j)))
+	requests <- NewNamedRequest("block")
+	<-started
+	queued := NewNamedRequest("sum", 4, 5)
+	requests <- queued
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	stop()
+	if res := queued.Result(); res.Err != ErrServerClosed {
+		t.Fatalf("queued request: %+v; want ErrServerClosed", res)
+	}
+	j.Close()
+
+	j = openJournal(t, path)
+	reqs := j.Recovered()
+	if len(reqs) != 1 || reqs[0].String() != "sum(4, 5)" {
+		t.Fatalf("recovered %v; want [sum(4, 5)]", reqs)
+	}
+	_, stop = serveServer(t, NewServer(WithRegistry(registry), WithJournal(j)))
+	if res := reqs[0].Result(); res.Value != 9 || res.Err != nil {
+		t.Errorf("redelivered request: %+v", res)
+	}
+	stop()
+	j.Close()
+
+	j = openJournal(t, path)
+	defer j.Close()
+	if reqs := j.Recovered(); len(reqs) != 0 {
+		t.Errorf("recovered %v after it was served", reqs)
+	}
+}
+
+// A crash can cut off the last line, but not one in the middle.
+func TestJournalDamagedLines(t *testing.T) {
+	dir := t.TempDir()
+	record := `{"op":"enqueued","id":1,"name":"sum","args":[1,2]}` + "\n"
+
+	torn := filepath.Join(dir, "torn")
+	if err := os.WriteFile(torn, []byte(record+`{"op":"enq`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	j := openJournal(t, torn)
+	if reqs := j.Recovered(); len(reqs) != 1 || reqs[0].String() != "sum(1, 2)" {
+		t.Errorf("recovered %v; want [sum(1, 2)]", reqs)
+	}
+	j.Close()
+
+	damaged := filepath.Join(dir, "damaged")
+	if err := os.WriteFile(damaged, []byte(`{"op":"enq`+"\n"+record), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := OpenJournal(damaged, time.Hour); err == nil {
+		j.Close()
+		t.Error("OpenJournal accepted a damaged line before the last one")
+	}
+}
@@ -0,0 +1,107 @@
+package concurrency
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+)
+
+// === BACKPRESSURE ===
+/*
+Without a limit the queue grows as long as calls come in faster than they are
+handled, and every call waits longer than the one before. WithQueueLimit bounds
+the queue; what happens to a call that finds it full is up to the overflow policy.
+Blocking passes the pressure on to the producer: Serve stops reading its channel,
+so senders block too. The other policies shed load: the arriving call, or one of
+the waiting ones, fails with ErrQueueFull.
+*/
+
+// ErrQueueFull is the error of a call that didn't fit into a full queue,
+// or was dropped from it to make room for another one.
+var ErrQueueFull = errors.New("concurrency: queue full")
+
+// OverflowPolicy says what happens to a call arriving at a full queue.
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // wait for room
+	OverflowReject                           // fail the arriving call
+	OverflowDropOldest                       // fail the call that has waited longest, queue the arriving one
+	OverflowDropNewest                       // fail the call that was queued last, queue the arriving one
+)
+
+func (o OverflowPolicy) String() string {
+	switch o {
+	case OverflowBlock:
+		return "block"
+	case OverflowReject:
+		return "reject"
+	case OverflowDropOldest:
+		return "drop oldest"
+	case OverflowDropNewest:
+		return "drop newest"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(o))
+}
+
+// WithQueueLimit lets no more than n calls wait for a handler, and decides with
+// overflow what happens to the ones that come when it is full. Calls collected
+// into batches count once their batch is queued, as one call, and never wait.
+func WithQueueLimit(n int, overflow OverflowPolicy) Option {
+	return func(c *config) {
+		c.queueLimit, c.overflow = n, overflow
+	}
+}
+
+// Cap reports the number of calls that may wait for a handler, or 0 for no limit.
+func (p *Pool[In, Out]) Cap() int {
+	return p.queue.limit
+}
+
+// full reports whether another call has no room in the queue.
+func (q *callQueue[In, Out]) full() bool {
+	return q.limit > 0 && q.len() >= q.limit
+}
+
+// evict takes the oldest or the newest call out of the queue.
+func (q *callQueue[In, Out]) evict(newest bool) (*call[In, Out], bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var victim *call[In, Out]
+	for _, t := range q.tenants {
+		for _, c := range t.calls {
+			if victim == nil || (c.seq > victim.seq) == newest {
+				victim = c
+			}
+		}
+	}
+	if victim == nil {
+		return nil, false
+	}
+	t := q.tenants[victim.tenant]
+	heap.Remove(&t.calls, victim.index)
+	q.n--
+	q.prune(t, victim.tenant)
+	return victim, true
+}
+
+// overflow makes room for another call if the pool's policy says so, and reports
+// whether there is room now. It must be called with p.mu held.
+func (p *Pool[In, Out]) overflow() bool {
+	if !p.queue.full() {
+		return true
+	}
+	switch p.queue.overflow {
+	case OverflowDropOldest, OverflowDropNewest:
+		victim, ok := p.queue.evict(p.queue.overflow == OverflowDropNewest)
+		if ok && victim.batch != nil {
+			for _, c := range victim.batch {
+				p.reject(c, ErrQueueFull)
+			}
+		} else if ok {
+			p.reject(victim, ErrQueueFull)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package concurrency
+
+import (
+	"time"
+)
+
+// === FAIR QUEUING ===
+/*
+Priorities decide which call goes first, but not who gets the handlers: a
+single client sending calls faster than they are handled keeps all of them busy.
+So calls belong to tenants, and the queue keeps one heap of calls per tenant.
+
+Every tenant has a virtual time, the handler time its calls used so far divided
+by its weight. The next call is taken from the waiting tenant with the smallest
+virtual time, so over time tenants get handler time in proportion to their
+weights. A tenant with nothing waiting drops out, and its share goes to the
+others; when it comes back it starts at the virtual time of the tenant served
+last, so it can't make up for the time it was away. Within a tenant calls go
+by priority as before.
+
+As a call's duration is only known when it is done, the tenant is charged an
+estimate when the call is taken off the queue, and the difference afterwards.
+*/
+
+// WithTenant sets the tenant a call is made for. Calls without one belong to
+// the tenant "".
+func WithTenant(tenant string) CallOption {
+	return func(c *callConfig) {
+		c.tenant = tenant
+	}
+}
+
+// WithTenantWeights sets the weights of tenants. Tenants not in weights,
+// and those with a weight that is not positive, have weight 1.
+func WithTenantWeights(weights map[string]float64) Option {
+	return func(c *config) {
+		c.weights = make(map[string]float64, len(weights))
+		for tenant, w := range weights {
+			c.weights[tenant] = w
+		}
+	}
+}
+
+// WithTenant sets the tenant the request is made for, see WithTenantWeights.
+func (req *Request) WithTenant(tenant string) *Request {
+	req.tenant = tenant
+	return req
+}
+
+// tenantQueue holds the waiting calls of a tenant.
+type tenantQueue[In, Out any] struct {
+	calls   callHeap[In, Out]
+	weight  float64
+	vtime   float64 // handler time used, in nanoseconds, divided by weight
+	running int     // calls taken off the queue and not charged yet
+}
+
+// tenant returns the queue of tenant, creating it if necessary. A new queue
+// starts at the current virtual time. It must be called with q.mu held.
+func (q *callQueue[In, Out]) tenant(tenant string) *tenantQueue[In, Out] {
+	t, ok := q.tenants[tenant]
+	if !ok {
+		t = &tenantQueue[In, Out]{weight: q.weights[tenant], vtime: q.vclock}
+		if t.weight <= 0 {
+			t.weight = 1
+		}
+		q.tenants[tenant] = t
+	} else if len(t.calls) == 0 && t.vtime < q.vclock {
+		t.vtime = q.vclock // no credit for the time it was away
+	}
+	return t
+}
+
+// next returns the waiting tenant with the smallest virtual time, or nil.
+// It must be called with q.mu held.
+func (q *callQueue[In, Out]) next() *tenantQueue[In, Out] {
+	var next *tenantQueue[In, Out]
+	for _, t := range q.tenants {
+		if len(t.calls) == 0 {
+			continue
+		}
+		if next == nil || t.vtime < next.vtime || t.vtime == next.vtime && t.calls[0].seq < next.calls[0].seq {
+			next = t
+		}
+	}
+	return next
+}
+
+// dispatched charges t the estimated cost of c, which was just taken off the
+// queue. It must be called with q.mu held.
+func (q *callQueue[In, Out]) dispatched(t *tenantQueue[In, Out], c *call[In, Out]) {
+	if t.vtime > q.vclock {
+		q.vclock = t.vtime
+	}
+	c.cost = q.estimate
+	t.vtime += float64(c.cost) / t.weight
+	t.running++
+}
+
+// charge corrects the charge of the tenant of c, now that c took d.
+func (q *callQueue[In, Out]) charge(c *call[In, Out], d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.estimate += (d - q.estimate) / 8
+	t, ok := q.tenants[c.tenant]
+	if !ok {
+		return
+	}
+	t.vtime += float64(d-c.cost) / t.weight
+	t.running--
+	q.prune(t, c.tenant)
+}
+
+// prune forgets t once it has nothing waiting or running, and no more time used
+// than a new tenant would start with. It must be called with q.mu held.
+func (q *callQueue[In, Out]) prune(t *tenantQueue[In, Out], tenant string) {
+	if len(t.calls) == 0 && t.running == 0 && t.vtime <= q.vclock {
+		delete(q.tenants, tenant)
+	}
+}
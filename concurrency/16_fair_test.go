@@ -0,0 +1,40 @@
+package concurrency
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tenants get handler time in proportion to their weights, whoever queued first.
+func TestFairShare(t *testing.T) {
+	op := newOrderPool(t, time.Millisecond, WithTenantWeights(map[string]float64{"a": 3}))
+	ctx := context.Background()
+	var futs []*Future[string]
+	for _, tenant := range []string{"a", "b"} {
+		for i := 0; i < 40; i++ {
+			futs = append(futs, op.SubmitAsync(ctx, tenant, WithTenant(tenant)))
+		}
+	}
+	first := op.wait(futs)[:20]
+	a := strings.Count(strings.Join(first, ""), "a")
+	// 3:1 makes 15 of 20; the estimates of the costs are not exact.
+	if a < 13 || a > 17 {
+		t.Errorf("tenant a ran %d of the first 20 calls (%q); want about 15", a, first)
+	}
+}
+
+// Within a tenant calls still go by priority.
+func TestFairSharePriorities(t *testing.T) {
+	op := newOrderPool(t, 0)
+	ctx := context.Background()
+	futs := []*Future[string]{
+		op.SubmitAsync(ctx, "a low", WithTenant("a"), WithPriority(PriorityLow)),
+		op.SubmitAsync(ctx, "a high", WithTenant("a"), WithPriority(PriorityHigh)),
+	}
+	want := []string{"a high", "a low"}
+	if got := op.wait(futs); !equal(got, want) {
+		t.Errorf("order %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package concurrency
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+)
+
+// === TRACING ===
+/*
+Metrics tell how long calls take on average; a trace tells where the time of one
+call went. With WithSpanExporter the pool records two spans for every attempt of
+a call: "queue", the time it waited for a handler, and "run", the time the
+handler took. Both belong to the trace of the call: the one in the context given
+to Submit, or for a Request the one set with WithTrace, or otherwise a new one.
+The handler's context carries the run span, so that work the handler starts can
+be traced as part of it. A batch, which runs for many calls at once, records a
+run span for each of them, and its function gets none of them in its context.
+*/
+
+// TraceID identifies a trace, SpanID a span within it.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsZero() bool { return id == TraceID{} }
+func (id SpanID) IsZero() bool  { return id == SpanID{} }
+
+func (id TraceID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+func (id SpanID) MarshalText() ([]byte, error)  { return []byte(id.String()), nil }
+
+func newTraceID() (id TraceID) {
+	for id.IsZero() {
+		for i := range id {
+			id[i] = byte(rand.Uint32())
+		}
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for id.IsZero() {
+		for i := range id {
+			id[i] = byte(rand.Uint32())
+		}
+	}
+	return id
+}
+
+// SpanContext is what a span passes on to its children.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying sc. Calls submitted
+// with it become part of the trace of sc, as children of its span.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanFromContext returns the span carried by ctx, if there is one.
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Span is a timed part of a trace.
+type Span struct {
+	TraceID  TraceID   `json:"trace_id"`
+	SpanID   SpanID    `json:"span_id"`
+	ParentID SpanID    `json:"parent_id,omitzero"`
+	Name     string    `json:"name"` // "queue" or "run"
+	Pool     string    `json:"pool"`
+	Handler  string    `json:"handler"`
+	Attempt  int       `json:"attempt"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Err      string    `json:"error,omitempty"`
+}
+
+// SpanExporter gets the spans of a pool as they end. ExportSpan is called
+// by the pool's handlers, so it shouldn't take long.
+type SpanExporter interface {
+	ExportSpan(s Span)
+}
+
+// WithSpanExporter makes the pool trace its calls and send the spans to e.
+func WithSpanExporter(e SpanExporter) Option {
+	return func(c *config) {
+		c.exporter = e
+	}
+}
+
+// WithTrace makes the request part of the trace of parent.
+func (req *Request) WithTrace(parent SpanContext) *Request {
+	req.trace = parent
+	return req
+}
+
+// JSONExporter writes spans as JSON, one per line.
+type JSONExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer // the file, if the exporter opened it
+	err error
+}
+
+// NewJSONExporter returns an exporter writing to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{enc: json.NewEncoder(w)}
+}
+
+// OpenJSONExporter returns an exporter appending to the file at path.
+func OpenJSONExporter(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	e := NewJSONExporter(f)
+	e.c = f
+	return e, nil
+}
+
+func (e *JSONExporter) ExportSpan(s Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(s); err != nil && e.err == nil {
+		e.err = err
+	}
+}
+
+// Close closes the file opened by OpenJSONExporter. It returns the first
+// error writing a span, if there was one.
+func (e *JSONExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var err error
+	if e.c != nil {
+		err = e.c.Close()
+	}
+	return errors.Join(e.err, err)
+}
+
+// trace exports the queue span of c, which starts running now, and returns the
+// context for running it and a function ending its run span.
+func (p *Pool[In, Out]) trace(c *call[In, Out], start time.Time) (context.Context, func(error)) {
+	if p.exporter == nil {
+		return c.ctx, func(error) {}
+	}
+	if c.trace.TraceID.IsZero() {
+		c.trace.TraceID = newTraceID()
+	}
+	span := Span{TraceID: c.trace.TraceID, ParentID: c.trace.SpanID, Pool: p.name, Handler: c.handler, Attempt: c.attempts}
+	if span.Handler == "" {
+		span.Handler = p.name
+	}
+	queue := span
+	queue.SpanID, queue.Name, queue.Start, queue.End = newSpanID(), "queue", c.queued, start
+	p.exporter.ExportSpan(queue)
+
+	span.SpanID, span.Name, span.Start = newSpanID(), "run", start
+	ctx := ContextWithSpan(c.ctx, SpanContext{TraceID: span.TraceID, SpanID: span.SpanID})
+	return ctx, func(err error) {
+		span.End = time.Now()
+		if err != nil {
+			span.Err = err.Error()
+		}
+		p.exporter.ExportSpan(span)
+	}
+}
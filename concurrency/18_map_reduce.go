@@ -0,0 +1,89 @@
+package concurrency
+
+import (
+	"fmt"
+	"math"
+)
+
+// === MAP AND REDUCE ===
+/*
+DoAll is hard-wired to one operation. The same split into pieces works for any
+operation that treats the elements independently (Map, ZipWith), and for any
+associative one that combines them (Reduce): every piece is reduced on its own,
+and the partial results are combined in the order of the pieces. All of them
+split as DefaultParallelism says, except that reductions use pieces of a fixed
+size, see ReduceBlock.
+*/
+
+// Map returns the vector of f(v[i]).
+func (v Vector) Map(f func(float64) float64) Vector {
+	w := make(Vector, len(v))
+	DefaultParallelism.do(len(v), func(_, i, n int) {
+		for ; i < n; i++ {
+			w[i] = f(v[i])
+		}
+	})
+	return w
+}
+
+// ZipWith returns the vector of f(v[i], u[i]). It panics if the lengths differ.
+func (v Vector) ZipWith(u Vector, f func(a, b float64) float64) Vector {
+	mustMatch(v, u)
+	w := make(Vector, len(v))
+	DefaultParallelism.do(len(v), func(_, i, n int) {
+		for ; i < n; i++ {
+			w[i] = f(v[i], u[i])
+		}
+	})
+	return w
+}
+
+// Reduce combines the elements of v with f, which has to be associative, and
+// for which identity has to be neutral: f(identity, x) == x. An empty vector
+// reduces to identity. The result doesn't depend on the number of CPUs.
+func (v Vector) Reduce(identity float64, f func(acc, x float64) float64) float64 {
+	par := DefaultParallelism.reduction()
+	pieces, _ := par.split(len(v))
+	partial := make([]float64, pieces)
+	par.do(len(v), func(p, i, n int) {
+		acc := identity
+		for ; i < n; i++ {
+			acc = f(acc, v[i])
+		}
+		partial[p] = acc
+	})
+	acc := identity
+	for _, x := range partial {
+		acc = f(acc, x)
+	}
+	return acc
+}
+
+// Sum returns the sum of the elements of v, using compensated summation.
+// The result doesn't depend on the number of CPUs.
+func (v Vector) Sum() float64 {
+	return sumTerms(len(v), func(i int) float64 { return v[i] })
+}
+
+// Min returns the smallest element of v, or +Inf if v is empty.
+func (v Vector) Min() float64 {
+	return v.Reduce(math.Inf(1), math.Min)
+}
+
+// Max returns the largest element of v, or -Inf if v is empty.
+func (v Vector) Max() float64 {
+	return v.Reduce(math.Inf(-1), math.Max)
+}
+
+// Dot returns the dot product of v and u, using compensated summation.
+// It panics if the lengths differ. The result doesn't depend on the number of CPUs.
+func (v Vector) Dot(u Vector) float64 {
+	mustMatch(v, u)
+	return sumTerms(len(v), func(i int) float64 { return v[i] * u[i] })
+}
+
+func mustMatch(v, u Vector) {
+	if len(v) != len(u) {
+		panic(fmt.Sprintf("concurrency: vectors of length %d and %d", len(v), len(u)))
+	}
+}
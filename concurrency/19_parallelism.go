@@ -0,0 +1,119 @@
+package concurrency
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// === CONFIGURABLE PARALLELISM ===
+/*
+One piece per CPU is too many for a vector of ten elements, where starting the
+goroutines costs more than the work, and too few for a huge one whose pieces
+take different times: the CPUs that are done early sit idle until the slowest
+piece is done. Parallelism tunes the split: vectors below a threshold are done
+sequentially, pieces have a minimum size, and in work-stealing mode the vector is
+cut into many small pieces that the workers take one after the other, so that a
+fast worker simply takes more of them.
+*/
+
+// DefaultStealChunk is the size of the pieces in work-stealing mode,
+// unless Parallelism.StealChunk says otherwise.
+const DefaultStealChunk = 1024
+
+// Parallelism says how vector operations are split into pieces.
+// The zero value splits into one piece per CPU, like DoAll always did.
+type Parallelism struct {
+	Workers    int  // goroutines at most; runtime.GOMAXPROCS(0) if not positive
+	MinChunk   int  // fewer pieces are used if they would be shorter than this
+	Sequential int  // vectors shorter than this are done without goroutines
+	Steal      bool // workers take pieces of StealChunk elements until none are left
+	StealChunk int  // DefaultStealChunk if not positive
+}
+
+// DefaultParallelism is used by DoAll, Map, ZipWith and Reduce. Reductions
+// like Reduce, Sum and Dot only take Workers from it, see ReduceBlock.
+var DefaultParallelism Parallelism
+
+// DoAllWith is DoAll, split as par says.
+func (v Vector) DoAllWith(u Vector, par Parallelism) {
+	par.do(len(v), func(_, i, n int) {
+		for ; i < n; i++ {
+			v[i] += u.Op(v[i])
+		}
+	})
+}
+
+// split returns the number of pieces for length elements, and the number of
+// goroutines to work on them; 0 means the caller does them one after the other.
+func (par Parallelism) split(length int) (pieces, workers int) {
+	workers = par.Workers
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if length < par.Sequential {
+		return 1, 0
+	}
+	pieces = workers
+	if par.Steal {
+		chunk := par.StealChunk
+		if chunk < 1 {
+			chunk = DefaultStealChunk
+		}
+		if chunk < par.MinChunk {
+			chunk = par.MinChunk
+		}
+		pieces = (length + chunk - 1) / chunk
+	} else if par.MinChunk > 0 && length/par.MinChunk < pieces {
+		pieces = length / par.MinChunk
+	}
+	if pieces < 1 {
+		pieces = 1
+	}
+	if workers > pieces {
+		workers = pieces
+	}
+	if workers == 1 {
+		workers = 0
+	}
+	return pieces, workers
+}
+
+// do calls f(piece, i, n) for the pieces of [0, length) and waits for them. Piece p
+// covers [p*length/pieces, (p+1)*length/pieces), so the pieces differ in length by
+// one at most.
+func (par Parallelism) do(length int, f func(piece, i, n int)) {
+	pieces, workers := par.split(length)
+	piece := func(p int) {
+		f(p, p*length/pieces, (p+1)*length/pieces)
+	}
+	if workers == 0 {
+		for p := 0; p < pieces; p++ {
+			piece(p)
+		}
+		return
+	}
+
+	// As in DoAll, the goroutines signal on a channel when they are done.
+	c := make(chan int, workers)
+	if !par.Steal { // then there is a piece for every worker
+		for p := 0; p < pieces; p++ {
+			go func(p int) {
+				piece(p)
+				c <- 1
+			}(p)
+		}
+	} else {
+		var next atomic.Int64
+		for w := 0; w < workers; w++ {
+			go func() {
+				for p := int(next.Add(1) - 1); p < pieces; p = int(next.Add(1) - 1) {
+					piece(p)
+				}
+				c <- 1
+			}()
+		}
+	}
+	for w := 0; w < workers; w++ {
+		<-c
+	}
+}
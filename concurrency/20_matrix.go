@@ -0,0 +1,131 @@
+package concurrency
+
+import (
+	"fmt"
+	"strings"
+)
+
+// === MATRICES ===
+/*
+A Transform like [3][3]float64 has its size in its type. A Matrix of any size
+keeps its elements in one slice, row after row, so that a row is a Vector and the
+whole matrix can be split into pieces like one. Element-wise operations split the
+elements, the products split the rows of the result.
+*/
+
+// Matrix is a dense matrix of Rows×Cols elements, stored row by row.
+type Matrix struct {
+	Rows, Cols int
+	Data       Vector // element (i, j) is Data[i*Cols+j]
+}
+
+// NewMatrix returns a matrix of rows×cols zeros.
+func NewMatrix(rows, cols int) Matrix {
+	return Matrix{Rows: rows, Cols: cols, Data: make(Vector, rows*cols)}
+}
+
+// At returns element (i, j).
+func (m Matrix) At(i, j int) float64 {
+	return m.Data[i*m.Cols+j]
+}
+
+// Set sets element (i, j) to x.
+func (m Matrix) Set(i, j int, x float64) {
+	m.Data[i*m.Cols+j] = x
+}
+
+// Row returns row i. It shares its elements with m.
+func (m Matrix) Row(i int) Vector {
+	return m.Data[i*m.Cols : (i+1)*m.Cols]
+}
+
+func (m Matrix) String() string {
+	var b strings.Builder
+	for i := 0; i < m.Rows; i++ {
+		fmt.Fprintln(&b, m.Row(i))
+	}
+	return b.String()
+}
+
+// Add returns m + n. It panics if their sizes differ.
+func (m Matrix) Add(n Matrix) Matrix {
+	if m.Rows != n.Rows || m.Cols != n.Cols {
+		panic(fmt.Sprintf("concurrency: adding %dx%d and %dx%d matrices", m.Rows, m.Cols, n.Rows, n.Cols))
+	}
+	return Matrix{Rows: m.Rows, Cols: m.Cols, Data: m.Data.ZipWith(n.Data, func(a, b float64) float64 { return a + b })}
+}
+
+// Scale returns f·m.
+func (m Matrix) Scale(f float64) Matrix {
+	return Matrix{Rows: m.Rows, Cols: m.Cols, Data: m.Data.Map(func(x float64) float64 { return f * x })}
+}
+
+// Transpose returns the transpose of m.
+func (m Matrix) Transpose() Matrix {
+	t := NewMatrix(m.Cols, m.Rows)
+	DefaultParallelism.do(t.Rows, func(_, i, n int) {
+		for ; i < n; i++ {
+			for j := 0; j < t.Cols; j++ {
+				t.Data[i*t.Cols+j] = m.Data[j*m.Cols+i]
+			}
+		}
+	})
+	return t
+}
+
+// MulVec returns the product m·v. It panics if v doesn't have m.Cols elements.
+func (m Matrix) MulVec(v Vector) Vector {
+	if len(v) != m.Cols {
+		panic(fmt.Sprintf("concurrency: multiplying %dx%d matrix with vector of length %d", m.Rows, m.Cols, len(v)))
+	}
+	w := make(Vector, m.Rows)
+	DefaultParallelism.do(m.Rows, func(_, i, n int) {
+		for ; i < n; i++ {
+			w[i] = m.Row(i).Dot(v)
+		}
+	})
+	return w
+}
+
+// Mul returns the product m·n. It panics if m.Cols != n.Rows.
+func (m Matrix) Mul(n Matrix) Matrix {
+	if m.Cols != n.Rows {
+		panic(fmt.Sprintf("concurrency: multiplying %dx%d and %dx%d matrices", m.Rows, m.Cols, n.Rows, n.Cols))
+	}
+	p := NewMatrix(m.Rows, n.Cols)
+	DefaultParallelism.do(m.Rows, func(_, i, end int) {
+		for ; i < end; i++ {
+			row := p.Row(i)
+			// Going through n row by row reads its elements in the order they are stored.
+			for k, x := range m.Row(i) {
+				for j, y := range n.Row(k) {
+					row[j] += x * y
+				}
+			}
+		}
+	})
+	return p
+}
+
+// FromTransform returns the 3×3 matrix with the elements of t,
+// which may be a Transform or any other [3][3]float64.
+func FromTransform(t [3][3]float64) Matrix {
+	m := NewMatrix(3, 3)
+	for i, row := range t {
+		copy(m.Row(i), row[:])
+	}
+	return m
+}
+
+// Transform returns the elements of m as a [3][3]float64, which converts to
+// a Transform. It fails if m is not 3×3.
+func (m Matrix) Transform() ([3][3]float64, error) {
+	var t [3][3]float64
+	if m.Rows != 3 || m.Cols != 3 {
+		return t, fmt.Errorf("concurrency: %dx%d matrix is not a transform", m.Rows, m.Cols)
+	}
+	for i := range t {
+		copy(t[i][:], m.Row(i))
+	}
+	return t, nil
+}
@@ -0,0 +1,115 @@
+package concurrency
+
+import (
+	"fmt"
+	"sort"
+)
+
+// === SPARSE VECTORS AND MATRICES ===
+/*
+A vector that is mostly zeros wastes memory as a Vector, and DoAll spends most of
+its time adding zeros. A SparseVector keeps only the elements that are not zero,
+with their indices. A CSR (compressed sparse row) matrix does the same for every
+row and keeps the rows one after the other in shared slices. Its product with a
+vector is split into pieces of rows; as rows may have very different numbers of
+elements, work-stealing (Parallelism.Steal) balances it better than the default.
+*/
+
+// SparseVector is a vector of Len elements that are zero except at Index.
+type SparseVector struct {
+	Len   int
+	Index []int     // ascending
+	Value []float64 // Value[k] is element Index[k]
+}
+
+// Sparse returns the elements of v that are not zero as a SparseVector.
+func (v Vector) Sparse() SparseVector {
+	s := SparseVector{Len: len(v)}
+	for i, x := range v {
+		if x != 0 {
+			s.Index = append(s.Index, i)
+			s.Value = append(s.Value, x)
+		}
+	}
+	return s
+}
+
+// Dense returns s as a Vector.
+func (s SparseVector) Dense() Vector {
+	v := make(Vector, s.Len)
+	for k, i := range s.Index {
+		v[i] = s.Value[k]
+	}
+	return v
+}
+
+// At returns element i.
+func (s SparseVector) At(i int) float64 {
+	if k := sort.SearchInts(s.Index, i); k < len(s.Index) && s.Index[k] == i {
+		return s.Value[k]
+	}
+	return 0
+}
+
+// Dot returns the dot product of s and v. It panics if the lengths differ.
+func (s SparseVector) Dot(v Vector) float64 {
+	if s.Len != len(v) {
+		panic(fmt.Sprintf("concurrency: vectors of length %d and %d", s.Len, len(v)))
+	}
+	return sumTerms(len(s.Index), func(k int) float64 { return s.Value[k] * v[s.Index[k]] })
+}
+
+// CSR is a sparse matrix in compressed sparse row format.
+type CSR struct {
+	Rows, Cols int
+	RowStart   []int     // the elements of row i are at RowStart[i]:RowStart[i+1] in Col and Value
+	Col        []int     // ascending within a row
+	Value      []float64 // Value[k] is element (i, Col[k]) of the row i it belongs to
+}
+
+// Sparse returns the elements of m that are not zero as a CSR matrix.
+func (m Matrix) Sparse() CSR {
+	a := CSR{Rows: m.Rows, Cols: m.Cols, RowStart: make([]int, m.Rows+1)}
+	for i := 0; i < m.Rows; i++ {
+		for j, x := range m.Row(i) {
+			if x != 0 {
+				a.Col = append(a.Col, j)
+				a.Value = append(a.Value, x)
+			}
+		}
+		a.RowStart[i+1] = len(a.Col)
+	}
+	return a
+}
+
+// Dense returns a as a Matrix.
+func (a CSR) Dense() Matrix {
+	m := NewMatrix(a.Rows, a.Cols)
+	for i := 0; i < a.Rows; i++ {
+		row := a.Row(i)
+		for k, j := range row.Index {
+			m.Set(i, j, row.Value[k])
+		}
+	}
+	return m
+}
+
+// Row returns row i. It shares its elements with a.
+func (a CSR) Row(i int) SparseVector {
+	start, end := a.RowStart[i], a.RowStart[i+1]
+	return SparseVector{Len: a.Cols, Index: a.Col[start:end], Value: a.Value[start:end]}
+}
+
+// MulVec returns the product a·v. It panics if v doesn't have a.Cols elements.
+func (a CSR) MulVec(v Vector) Vector {
+	if len(v) != a.Cols {
+		panic(fmt.Sprintf("concurrency: multiplying %dx%d matrix with vector of length %d", a.Rows, a.Cols, len(v)))
+	}
+	w := make(Vector, a.Rows)
+	DefaultParallelism.do(a.Rows, func(_, i, n int) {
+		for ; i < n; i++ {
+			w[i] = a.Row(i).Dot(v)
+		}
+	})
+	return w
+}
@@ -0,0 +1,122 @@
+package concurrency
+
+import (
+	"math"
+)
+
+// === ACCURATE SUMS ===
+/*
+Adding floating-point numbers one after the other rounds after every addition,
+and the errors add up: the error of a naive sum grows with the number of terms.
+Compensated summation (Kahan) carries the rounding error of every addition along
+and adds it back, so the error doesn't grow with the number of terms; Neumaier's
+variant also copes with terms larger than the sum so far. Pairwise summation adds
+the halves of the terms separately and then the two sums, which keeps the error
+growing only with the logarithm of the number of terms, almost as fast as the
+naive loop.
+
+Rounding also makes floating-point addition not associative, so a parallel sum
+depends on how the terms are split. Reductions on a Vector therefore split into
+pieces of ReduceBlock elements, however many CPUs there are, and combine the
+partial results in the order of the pieces: the same vector gives the same
+result on every machine. The dot products of sparse vectors and the elements of
+MulVec, dense or sparse, are summed in the same way.
+*/
+
+// ReduceBlock is the size of the pieces of Reduce, Sum and Dot.
+const ReduceBlock = 4096
+
+// pairwiseBlock is the number of terms PairwiseSum adds in a simple loop.
+const pairwiseBlock = 128
+
+// KahanSum returns the sum of xs, using Kahan's compensated summation.
+func KahanSum(xs []float64) float64 {
+	var sum, c float64
+	for _, x := range xs {
+		y := x - c
+		t := sum + y
+		c = (t - sum) - y // what was lost of y
+		sum = t
+	}
+	return sum
+}
+
+// NeumaierSum returns the sum of xs, using Neumaier's variant of Kahan's
+// compensated summation.
+func NeumaierSum(xs []float64) float64 {
+	var s neumaier
+	for _, x := range xs {
+		s.add(x)
+	}
+	return s.value()
+}
+
+// PairwiseSum returns the sum of xs, adding them pairwise.
+func PairwiseSum(xs []float64) float64 {
+	if len(xs) <= pairwiseBlock {
+		var sum float64
+		for _, x := range xs {
+			sum += x
+		}
+		return sum
+	}
+	m := len(xs) / 2
+	return PairwiseSum(xs[:m]) + PairwiseSum(xs[m:])
+}
+
+// neumaier is a running sum with Neumaier's compensation.
+type neumaier struct {
+	sum, c float64 // c is what was lost of the sum by rounding
+}
+
+func (n *neumaier) add(x float64) {
+	t := n.sum + x
+	if math.Abs(n.sum) >= math.Abs(x) {
+		n.c += (n.sum - t) + x
+	} else {
+		n.c += (x - t) + n.sum
+	}
+	n.sum = t
+}
+
+// merge adds the terms of m to n.
+func (n *neumaier) merge(m neumaier) {
+	n.add(m.sum)
+	n.add(m.c)
+}
+
+func (n neumaier) value() float64 {
+	if math.IsInf(n.sum, 0) || math.IsNaN(n.sum) {
+		return n.sum // c is NaN then
+	}
+	return n.sum + n.c
+}
+
+// sumTerms returns the sum of term(i) for i in [0, n), split into pieces of
+// ReduceBlock terms, each summed with Neumaier's compensation.
+func sumTerms(n int, term func(i int) float64) float64 {
+	par := DefaultParallelism.reduction()
+	pieces, _ := par.split(n)
+	partial := make([]neumaier, pieces)
+	par.do(n, func(p, i, end int) {
+		var s neumaier
+		for ; i < end; i++ {
+			s.add(term(i))
+		}
+		partial[p] = s
+	})
+	var total neumaier
+	for _, s := range partial {
+		total.merge(s)
+	}
+	return total.value()
+}
+
+// reduction returns how par splits reductions: in pieces of ReduceBlock
+// elements, taken by as many workers as par says. Sequential and MinChunk
+// would change the pieces, and so the result, so they don't apply.
+func (par Parallelism) reduction() Parallelism {
+	par.Steal, par.StealChunk = true, ReduceBlock
+	par.Sequential, par.MinChunk = 0, 0
+	return par
+}
@@ -0,0 +1,65 @@
+package concurrency
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompensatedSums(t *testing.T) {
+	// Naively 1e16 + 1 + 1 - 1e16 is 0.
+	xs := []float64{1e16, 1, 1, -1e16}
+	if got := NeumaierSum(xs); got != 2 {
+		t.Errorf("NeumaierSum = %v; want 2", got)
+	}
+	if got := Vector(xs).Sum(); got != 2 {
+		t.Errorf("Sum = %v; want 2", got)
+	}
+	ones := Vector{1, 1, 1, 1}
+	if got := Vector(xs).Dot(ones); got != 2 {
+		t.Errorf("Dot = %v; want 2", got)
+	}
+	if got := Vector(xs).Sparse().Dot(ones); got != 2 {
+		t.Errorf("SparseVector.Dot = %v; want 2", got)
+	}
+	m := Matrix{Rows: 1, Cols: 4, Data: xs}
+	if got := m.MulVec(ones)[0]; got != 2 {
+		t.Errorf("MulVec = %v; want 2", got)
+	}
+	if got := m.Sparse().MulVec(ones)[0]; got != 2 {
+		t.Errorf("CSR.MulVec = %v; want 2", got)
+	}
+	if got := NeumaierSum([]float64{math.Inf(1), 1}); !math.IsInf(got, 1) {
+		t.Errorf("NeumaierSum with +Inf = %v", got)
+	}
+}
+
+// Reductions give the same result however DefaultParallelism is set.
+func TestReductionsDeterministic(t *testing.T) {
+	v := make(Vector, 100000)
+	for i := range v {
+		v[i] = math.Sin(float64(i)) * math.Pow(10, float64(i%7))
+	}
+	add := func(a, b float64) float64 { return a + b }
+
+	defer func(par Parallelism) { DefaultParallelism = par }(DefaultParallelism)
+	DefaultParallelism = Parallelism{}
+	reduce, sum, dot := v.Reduce(0, add), v.Sum(), v.Dot(v)
+	for _, par := range []Parallelism{
+		{Workers: 1},
+		{Workers: 3},
+		{Sequential: 1 << 20},
+		{MinChunk: 30000},
+		{Steal: true, StealChunk: 7},
+	} {
+		DefaultParallelism = par
+		if got := v.Reduce(0, add); got != reduce {
+			t.Errorf("%+v: Reduce = %v; want %v", par, got, reduce)
+		}
+		if got := v.Sum(); got != sum {
+			t.Errorf("%+v: Sum = %v; want %v", par, got, sum)
+		}
+		if got := v.Dot(v); got != dot {
+			t.Errorf("%+v: Dot = %v; want %v", par, got, dot)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package concurrency
+
+import "fmt"
+
+// === SPLIT AND MERGE ===
+/*
+SimpleChannelExample sums the halves of a slice in two goroutines. Received from
+one channel, the sums come in the order the goroutines finish, not in the order
+of the halves, so each is sent with the index of its half. SplitMerge does this
+for any number of pieces and any types: it cuts a slice into chunks, calls a
+function on every chunk in its own goroutine and puts the results back in chunk order.
+*/
+
+// indexed is a result tagged with the index of the chunk it belongs to.
+type indexed[R any] struct {
+	index int
+	value R
+}
+
+// SplitMerge cuts xs into chunks consecutive pieces of nearly equal length, calls f
+// on every piece in its own goroutine and returns the results in the order of the
+// pieces. If there are more chunks than elements, some pieces are empty. It
+// panics if chunks is less than 1.
+//
+// Unlike a Pool or a pipeline stage, SplitMerge doesn't recover panics: a panic
+// in f crashes the program.
+func SplitMerge[T, R any](xs []T, chunks int, f func(chunk []T) R) []R {
+	if chunks < 1 {
+		panic(fmt.Sprintf("concurrency: splitting into %d chunks", chunks))
+	}
+	c := make(chan indexed[R])
+	for i := 0; i < chunks; i++ {
+		chunk := xs[i*len(xs)/chunks : (i+1)*len(xs)/chunks]
+		go func() {
+			c <- indexed[R]{i, f(chunk)}
+		}()
+	}
+	rs := make([]R, chunks)
+	for range chunks {
+		r := <-c
+		rs[r.index] = r.value
+	}
+	return rs
+}
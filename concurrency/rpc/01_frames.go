@@ -0,0 +1,113 @@
+// Package rpc makes the request pool of package concurrency reachable over the network.
+//
+// Every message is a frame: a 4 byte big-endian length followed by that many
+// bytes of JSON. A request names a method, that is a handler registered with
+// the server's concurrency.Registry, and carries its arguments and an ID; the
+// response carries the same ID, so many calls can be in flight on one connection
+// and be answered in any order.
+package rpc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang-tests/concurrency"
+)
+
+// MaxFrameSize is the largest frame accepted from the other side.
+const MaxFrameSize = 1 << 20
+
+type request struct {
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Args    []int         `json:"args"`
+	Timeout time.Duration `json:"timeout,omitempty"` // what is left of the caller's deadline
+}
+
+type response struct {
+	ID     uint64 `json:"id"`
+	Result int    `json:"result"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"` // identifies well-known errors, see codes
+}
+
+// codes are the errors that keep their identity when they are sent to a client.
+var codes = map[string]error{
+	"unknown_method": concurrency.ErrUnknownHandler,
+	"invalid_args":   concurrency.ErrInvalidArgs,
+	"timeout":        concurrency.ErrTimeout,
+	"rate_limited":   concurrency.ErrRateLimited,
+	"closed":         concurrency.ErrServerClosed,
+	"circuit_open":   concurrency.ErrCircuitOpen,
+	"queue_full":     concurrency.ErrQueueFull,
+}
+
+func codeOf(err error) string {
+	for code, known := range codes {
+		if errors.Is(err, known) {
+			return code
+		}
+	}
+	return ""
+}
+
+// Error is an error returned by the method on the server.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+func (r *response) err() error {
+	if r.Error == "" && r.Code == "" {
+		return nil
+	}
+	if known, ok := codes[r.Code]; ok {
+		if r.Error == known.Error() {
+			return known
+		}
+		return &wrapped{msg: r.Error, err: known} // keep the details
+	}
+	return Error(r.Error)
+}
+
+// wrapped has the message of the server's error and the identity of the known error behind it.
+type wrapped struct {
+	msg string
+	err error
+}
+
+func (w *wrapped) Error() string { return w.msg }
+func (w *wrapped) Unwrap() error { return w.err }
+
+func writeFrame(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, v interface{}) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxFrameSize {
+		return fmt.Errorf("rpc: frame of %d bytes is too large", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
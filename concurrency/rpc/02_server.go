@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-tests/concurrency"
+)
+
+// Server answers calls using concurrency.ServeContext. The methods it offers are
+// the handlers in concurrency.DefaultRegistry, or the registry given by
+// concurrency.WithRegistry.
+type Server struct {
+	opts  []concurrency.Option
+	conns atomic.Uint64 // numbers the connections, for their rate limit keys
+}
+
+// NewServer returns a server whose pool is configured by opts.
+func NewServer(opts ...concurrency.Option) *Server {
+	return &Server{opts: opts}
+}
+
+// ListenAndServe listens on the given network ("tcp", "unix", ...) and address
+// and serves connections from there until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve accepts connections on l until ctx is cancelled. Then it closes l,
+// shuts the pool down like concurrency.ServeContext does, sends the outstanding
+// answers and closes all connections before it returns.
+// Calls are rate limited per connection (see concurrency.WithKeyRateLimit).
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	// A broken listener shuts everything down as well.
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	requests := make(chan *concurrency.Request)
+	served := make(chan error, 1)
+	go func() { served <- concurrency.ServeContext(serveCtx, requests, s.opts...) }()
+
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]bool)
+		wg    sync.WaitGroup
+	)
+	context.AfterFunc(serveCtx, func() { l.Close() })
+
+	var err error
+	for {
+		conn, aerr := l.Accept()
+		if aerr != nil {
+			if ctx.Err() == nil {
+				err = aerr // the listener broke, not a shutdown
+			}
+			break
+		}
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(serveCtx, conn, requests)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+
+	cancel()
+	<-served // every request has been answered or rejected now
+	// Stop reading; serveConn still writes the outstanding answers and then closes conn.
+	mu.Lock()
+	for conn := range conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	mu.Unlock()
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// serveConn reads calls from conn until it is closed or can't be read anymore,
+// answers them and closes conn.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn, requests chan<- *concurrency.Request) {
+	defer conn.Close()
+	var (
+		wmu     sync.Mutex // one writer at a time
+		pending sync.WaitGroup
+	)
+	reply := func(resp *response) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		writeFrame(conn, resp) // if the client is gone, so is its interest in the answer
+	}
+	// Unix socket clients all have the same address, so the number tells them apart.
+	key := conn.RemoteAddr().String() + "#" + strconv.FormatUint(s.conns.Add(1), 10)
+
+	for {
+		var req request
+		if err := readFrame(conn, &req); err != nil {
+			break
+		}
+		r := concurrency.NewNamedRequest(req.Method, req.Args...).WithKey(key)
+		if req.Timeout > 0 {
+			r.WithTimeout(req.Timeout)
+		}
+		select {
+		case requests <- r:
+		case <-ctx.Done():
+			reply(errorResponse(req.ID, concurrency.ErrServerClosed))
+			continue
+		}
+		pending.Add(1)
+		go func(id uint64) {
+			defer pending.Done()
+			res := r.Result()
+			if res.Err != nil {
+				reply(errorResponse(id, res.Err))
+				return
+			}
+			reply(&response{ID: id, Result: res.Value})
+		}(req.ID)
+	}
+	pending.Wait()
+}
+
+func errorResponse(id uint64, err error) *response {
+	return &response{ID: id, Error: err.Error(), Code: codeOf(err)}
+}
@@ -0,0 +1,147 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-tests/concurrency"
+)
+
+// serve starts s on a fresh Unix socket and returns its address and a function
+// that cancels it and returns what Serve returned.
+func serve(t *testing.T, s *Server) (string, func() error) {
+	t.Helper()
+	l, err := net.Listen("unix", t.TempDir()+"/rpc.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, l) }()
+	stop := func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Serve did not return")
+			return nil
+		}
+	}
+	t.Cleanup(func() { cancel() })
+	return l.Addr().String(), stop
+}
+
+func TestCall(t *testing.T) {
+	registry := concurrency.NewRegistry()
+	registry.Register("mul", concurrency.Schema{Args: []string{"a", "b"}},
+		func(_ context.Context, args []int) (int, error) { return args[0] * args[1], nil })
+	registry.Register("fail", concurrency.Schema{},
+		func(context.Context, []int) (int, error) { return 0, errors.New("boom") })
+	addr, stop := serve(t, NewServer(concurrency.WithRegistry(registry)))
+	c, err := Dial(context.Background(), "unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if v, err := c.Call(ctx, "mul", 3, 4); v != 12 || err != nil {
+		t.Errorf("mul(3, 4) = %v, %v; want 12", v, err)
+	}
+	if _, err := c.Call(ctx, "mul", 3); !errors.Is(err, concurrency.ErrInvalidArgs) {
+		t.Errorf("mul(3): %v; want ErrInvalidArgs", err)
+	}
+	if _, err := c.Call(ctx, "nope"); !errors.Is(err, concurrency.ErrUnknownHandler) {
+		t.Errorf("nope(): %v; want ErrUnknownHandler", err)
+	}
+	if _, err := c.Call(ctx, "fail"); err == nil || err.Error() != "boom" {
+		t.Errorf("fail(): %v; want boom", err)
+	}
+	if err := stop(); err != context.Canceled {
+		t.Errorf("Serve returned %v", err)
+	}
+}
+
+// Calls that are being handled when the server shuts down still get their answers.
+func TestShutdownSendsOutstandingAnswers(t *testing.T) {
+	const calls = 8
+	for round := 0; round < 10; round++ {
+		registry := concurrency.NewRegistry()
+		started := make(chan bool, calls)
+		registry.Register("slow", concurrency.Schema{Args: []string{"x"}}, func(_ context.Context, args []int) (int, error) {
+			started <- true
+			time.Sleep(20 * time.Millisecond)
+			return args[0], nil
+		})
+		addr, stop := serve(t, NewServer(concurrency.WithRegistry(registry), concurrency.WithWorkers(calls)))
+		c, err := Dial(context.Background(), "unix", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if v, err := c.Call(context.Background(), "slow", i); v != i || err != nil {
+					t.Errorf("slow(%d) = %v, %v", i, v, err)
+				}
+			}()
+		}
+		for i := 0; i < calls; i++ {
+			<-started
+		}
+		stop()
+		wg.Wait()
+		c.Close()
+	}
+}
+
+func TestShutdownRejectsLaterCalls(t *testing.T) {
+	addr, stop := serve(t, NewServer(concurrency.WithRegistry(concurrency.NewRegistry())))
+	c, err := Dial(context.Background(), "unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	stop()
+	if _, err := c.Call(context.Background(), "sum", 1); err == nil {
+		t.Error("call after shutdown succeeded")
+	}
+}
+
+// Unix socket clients all have the same remote address, but each connection has
+// its own rate limit.
+func TestRateLimitPerConnection(t *testing.T) {
+	registry := concurrency.NewRegistry()
+	registry.Register("one", concurrency.Schema{}, func(context.Context, []int) (int, error) { return 1, nil })
+	addr, _ := serve(t, NewServer(concurrency.WithRegistry(registry),
+		concurrency.WithKeyRateLimit(0.001, 1), concurrency.WithLimitMode(concurrency.LimitReject)))
+	ctx := context.Background()
+	a, err := Dial(ctx, "unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Dial(ctx, "unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, err := a.Call(ctx, "one"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Call(ctx, "one"); !errors.Is(err, concurrency.ErrRateLimited) {
+		t.Errorf("second call of a: %v; want ErrRateLimited", err)
+	}
+	if _, err := b.Call(ctx, "one"); err != nil {
+		t.Errorf("first call of b: %v", err)
+	}
+}
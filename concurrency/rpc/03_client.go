@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClientClosed is returned by calls on a closed Client.
+var ErrClientClosed = errors.New("rpc: client closed")
+
+// Client calls methods of a Server. It is safe for concurrent use; all calls
+// share the client's connection.
+type Client struct {
+	conn net.Conn
+	wmu  sync.Mutex // one writer at a time
+
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan *response
+	err     error // why the connection is no longer usable
+}
+
+// Dial connects to the server at address on the given network.
+func Dial(ctx context.Context, network, address string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client talking to a server over conn.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{conn: conn, pending: make(map[uint64]chan *response)}
+	go c.read()
+	return c
+}
+
+// Call calls method with args on the server and waits for the result.
+// The deadline of ctx, if any, is passed on to the server.
+func (c *Client) Call(ctx context.Context, method string, args ...int) (int, error) {
+	req := &request{Method: method, Args: args}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = time.Until(deadline)
+		if req.Timeout <= 0 {
+			return 0, context.DeadlineExceeded
+		}
+	}
+	done := make(chan *response, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, c.err
+	}
+	c.next++
+	req.ID = c.next
+	c.pending[req.ID] = done
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err := writeFrame(c.conn, req)
+	c.wmu.Unlock()
+	if err != nil {
+		c.forget(req.ID)
+		return 0, err
+	}
+
+	select {
+	case resp, ok := <-done:
+		if !ok {
+			return 0, c.broken()
+		}
+		return resp.Result, resp.err()
+	case <-ctx.Done():
+		c.forget(req.ID) // the answer will be dropped when it comes
+		return 0, ctx.Err()
+	}
+}
+
+// Close closes the connection. Calls still waiting fail with ErrClientClosed.
+func (c *Client) Close() error {
+	c.shutdown(ErrClientClosed)
+	return c.conn.Close()
+}
+
+// read dispatches responses to their calls until the connection breaks.
+func (c *Client) read() {
+	for {
+		var resp response
+		if err := readFrame(c.conn, &resp); err != nil {
+			c.shutdown(err)
+			return
+		}
+		c.mu.Lock()
+		done, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.mu.Unlock()
+		if ok {
+			done <- &resp
+		}
+	}
+}
+
+func (c *Client) forget(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// shutdown fails all waiting calls with err, unless the client is shut down already.
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	for id, done := range c.pending {
+		close(done)
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) broken() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
@@ -0,0 +1,152 @@
+// Package geometry makes the Transform of the arrays example a real 2D transform.
+//
+// A Transform is a 3×3 matrix acting on points in homogeneous coordinates: the
+// point (x, y) is the column (x, y, 1), and t maps it to t·(x, y, 1). The
+// constructors return affine transforms, whose last row is (0, 0, 1).
+//
+// Floating-point results are not exact. For transforms with entries of moderate
+// size, results agree with the exact ones to within Tolerance relative to the
+// magnitude of the values involved; use ApproxEqual to compare them. Rotations by
+// multiples of a quarter turn are exact.
+package geometry
+
+import (
+	"errors"
+	"math"
+)
+
+// Tolerance is the relative tolerance of the results, see the package documentation.
+const Tolerance = 1e-9
+
+// ErrSingular is the error of inverting a transform that has no inverse.
+var ErrSingular = errors.New("geometry: singular transform")
+
+// Transform is a 2D transform in homogeneous coordinates.
+type Transform [3][3]float64 // A 3x3 array, really an array of arrays.
+
+// Point is a point in the plane.
+type Point struct {
+	X, Y float64
+}
+
+// Identity returns the transform that leaves every point where it is.
+func Identity() Transform {
+	return Transform{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+}
+
+// Translate returns the transform moving points by (dx, dy).
+func Translate(dx, dy float64) Transform {
+	return Transform{{1, 0, dx}, {0, 1, dy}, {0, 0, 1}}
+}
+
+// Rotate returns the transform rotating points counterclockwise by theta radians around the origin.
+func Rotate(theta float64) Transform {
+	s, c := math.Sincos(theta)
+	if q := theta / (math.Pi / 2); q == math.Trunc(q) && math.Abs(q) < 1<<53 {
+		// Sincos(math.Pi) is not exactly (0, -1), as math.Pi is not exactly π.
+		s, c = [4]float64{0, 1, 0, -1}[mod4(q)], [4]float64{1, 0, -1, 0}[mod4(q)]
+	}
+	return Transform{{c, -s, 0}, {s, c, 0}, {0, 0, 1}}
+}
+
+func mod4(q float64) int {
+	return int(math.Mod(math.Mod(q, 4)+4, 4))
+}
+
+// Scale returns the transform scaling points by sx along x and sy along y, away from the origin.
+func Scale(sx, sy float64) Transform {
+	return Transform{{sx, 0, 0}, {0, sy, 0}, {0, 0, 1}}
+}
+
+// Shear returns the transform mapping (x, y) to (x + kx·y, y + ky·x).
+func Shear(kx, ky float64) Transform {
+	return Transform{{1, kx, 0}, {ky, 1, 0}, {0, 0, 1}}
+}
+
+// Mul returns the matrix product t·u, the transform applying u first and then t.
+func (t Transform) Mul(u Transform) Transform {
+	var p Transform
+	for i := range p {
+		for j := range p[i] {
+			p[i][j] = t[i][0]*u[0][j] + t[i][1]*u[1][j] + t[i][2]*u[2][j]
+		}
+	}
+	return p
+}
+
+// Then returns the transform applying t first and then u, that is u·t.
+// Transforms compose from left to right like this:
+//
+//	Translate(-cx, -cy).Then(Rotate(theta)).Then(Translate(cx, cy)) // rotation around (cx, cy)
+func (t Transform) Then(u Transform) Transform {
+	return u.Mul(t)
+}
+
+// Inverse returns the transform undoing t. It fails with ErrSingular if t maps
+// the plane onto a line or a point, up to Tolerance: if its determinant is not
+// larger than Tolerance times the product of the lengths of its rows, leaving out
+// the translation column of the first two rows. A translation doesn't make a
+// transform any closer to singular, however far it goes.
+func (t Transform) Inverse() (Transform, error) {
+	// The inverse is the adjugate, the transposed matrix of cofactors, divided by the determinant.
+	var adj Transform
+	for i := range adj {
+		for j := range adj[i] {
+			r0, r1 := (j+1)%3, (j+2)%3
+			c0, c1 := (i+1)%3, (i+2)%3
+			adj[i][j] = t[r0][c0]*t[r1][c1] - t[r0][c1]*t[r1][c0]
+		}
+	}
+	det := t[0][0]*adj[0][0] + t[0][1]*adj[1][0] + t[0][2]*adj[2][0]
+	bound := math.Hypot(t[0][0], t[0][1]) * math.Hypot(t[1][0], t[1][1]) * math.Hypot(math.Hypot(t[2][0], t[2][1]), t[2][2])
+	if !(math.Abs(det) > Tolerance*bound) { // also catches NaN
+		return Transform{}, ErrSingular
+	}
+	for i := range adj {
+		for j := range adj[i] {
+			adj[i][j] /= det
+		}
+	}
+	return adj, nil
+}
+
+// Apply returns the point t maps p to. For transforms that are not affine
+// the result is divided by its third coordinate.
+func (t Transform) Apply(p Point) Point {
+	x := t[0][0]*p.X + t[0][1]*p.Y + t[0][2]
+	y := t[1][0]*p.X + t[1][1]*p.Y + t[1][2]
+	if w := t[2][0]*p.X + t[2][1]*p.Y + t[2][2]; w != 1 {
+		x, y = x/w, y/w
+	}
+	return Point{x, y}
+}
+
+// ApplyAll maps the points ps in place and returns them.
+func (t Transform) ApplyAll(ps []Point) []Point {
+	for i, p := range ps {
+		ps[i] = t.Apply(p)
+	}
+	return ps
+}
+
+// ApproxEqual reports whether t and u agree to within Tolerance,
+// relative to the larger of the two entries, or absolutely for entries below 1.
+func (t Transform) ApproxEqual(u Transform) bool {
+	for i := range t {
+		for j := range t[i] {
+			if !approxEqual(t[i][j], u[i][j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ApproxEqual reports whether p and q agree to within Tolerance, like Transform.ApproxEqual.
+func (p Point) ApproxEqual(q Point) bool {
+	return approxEqual(p.X, q.X) && approxEqual(p.Y, q.Y)
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) <= Tolerance*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
@@ -0,0 +1,318 @@
+// Package pipeline connects typed stages with channels.
+//
+// SimpleChannelExample in package concurrency sums the two halves of a slice in
+// two goroutines and collects the sums from one channel. A pipeline does the
+// same for any number of steps: a Source sends values down a channel, every Stage
+// reads from the channel before it and writes to a new one, using as many
+// goroutines as it is told, and Collect gathers what comes out at the end. Merge
+// joins several channels into one.
+//
+// All stages of a pipeline share its context. The first stage that fails cancels
+// it, which makes every other stage stop, and Wait reports that first error.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Pipeline is a set of stages that stop together.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	err    error
+	waited bool // err is final
+}
+
+// New returns an empty pipeline that stops when ctx is done.
+func New(ctx context.Context) *Pipeline {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Pipeline{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the context shared by the stages. It is done when the
+// pipeline was stopped, by its parent context or by a failing stage, and
+// once Wait has returned.
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Stop stops all stages, as if one of them had failed with err.
+// After Wait has returned it does nothing.
+func (p *Pipeline) Stop(err error) {
+	p.mu.Lock()
+	if p.err == nil && !p.waited {
+		p.err = err
+	}
+	p.mu.Unlock()
+	p.cancel(err)
+}
+
+// Wait waits for all goroutines of the pipeline to exit and returns the error
+// that stopped it, or the error of its parent context, or nil. Unless the pipeline
+// is stopped, the goroutines only exit once all channels have been read to the end.
+// Then it releases the context of the pipeline.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.waited {
+		p.waited = true
+		if p.err == nil {
+			p.err = context.Cause(p.ctx)
+		}
+		p.cancel(p.err) // nil is context.Canceled
+	}
+	return p.err
+}
+
+func (p *Pipeline) goFunc(f func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		f()
+	}()
+}
+
+// send sends v on out unless the pipeline is stopped first, and reports whether it did.
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// receive receives from in unless the pipeline is stopped first. It reports
+// false if it is stopped or in is closed.
+func receive[T any](ctx context.Context, in <-chan T) (T, bool) {
+	select {
+	case v, ok := <-in:
+		return v, ok
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	}
+}
+
+// Option configures a stage.
+type Option func(*stageConfig)
+
+type stageConfig struct {
+	workers int
+	ordered bool
+	buffer  int
+}
+
+// WithWorkers runs the stage in n goroutines instead of one.
+func WithWorkers(n int) Option {
+	return func(c *stageConfig) {
+		c.workers = n
+	}
+}
+
+// WithOrder makes a stage with several workers send its results in the order
+// of its inputs. Without it they are sent as soon as they are ready.
+func WithOrder() Option {
+	return func(c *stageConfig) {
+		c.ordered = true
+	}
+}
+
+// WithBuffer gives the output channel of the stage a buffer of n values.
+func WithBuffer(n int) Option {
+	return func(c *stageConfig) {
+		c.buffer = n
+	}
+}
+
+func newStageConfig(opts []Option) stageConfig {
+	cfg := stageConfig{workers: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.workers < 1 {
+		cfg.workers = 1
+	}
+	return cfg
+}
+
+// Source returns a channel sending the values of items, one after the other.
+func Source[T any](p *Pipeline, items []T, opts ...Option) <-chan T {
+	out := make(chan T, newStageConfig(opts).buffer)
+	p.goFunc(func() {
+		defer close(out)
+		for _, v := range items {
+			if !send(p.ctx, out, v) {
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Stage returns a channel sending f(v) for every value v received from in.
+// If f fails, the pipeline is stopped with its error. A panic in f is
+// turned into an error in the same way.
+func Stage[In, Out any](p *Pipeline, in <-chan In, f func(ctx context.Context, v In) (Out, error), opts ...Option) <-chan Out {
+	cfg := newStageConfig(opts)
+	out := make(chan Out, cfg.buffer)
+	if cfg.ordered && cfg.workers > 1 {
+		stageOrdered(p, in, f, cfg.workers, out)
+		return out
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.workers; w++ {
+		wg.Add(1)
+		p.goFunc(func() {
+			defer wg.Done()
+			for {
+				v, ok := receive(p.ctx, in)
+				if !ok {
+					return
+				}
+				r, err := call(p.ctx, f, v)
+				if err != nil {
+					p.Stop(err)
+					return
+				}
+				if !send(p.ctx, out, r) {
+					return
+				}
+			}
+		})
+	}
+	p.goFunc(func() {
+		wg.Wait()
+		close(out)
+	})
+	return out
+}
+
+// stageOrdered is Stage with several workers and results in order. Every input gets
+// a slot for its result, and the slots are queued in the order of the inputs.
+// The queue has room for as many slots as there are workers, which keeps the
+// workers from running ahead of a slow input too far.
+func stageOrdered[In, Out any](p *Pipeline, in <-chan In, f func(context.Context, In) (Out, error), workers int, out chan<- Out) {
+	type job struct {
+		v    In
+		slot chan Out
+	}
+	jobs := make(chan job)
+	slots := make(chan chan Out, workers)
+	p.goFunc(func() {
+		defer close(jobs)
+		defer close(slots)
+		for {
+			v, ok := receive(p.ctx, in)
+			if !ok {
+				return
+			}
+			j := job{v: v, slot: make(chan Out, 1)}
+			if !send(p.ctx, slots, j.slot) || !send(p.ctx, jobs, j) {
+				return
+			}
+		}
+	})
+	for w := 0; w < workers; w++ {
+		p.goFunc(func() {
+			for j := range jobs {
+				r, err := call(p.ctx, f, j.v)
+				if err != nil {
+					p.Stop(err)
+					return
+				}
+				j.slot <- r // never blocks, the slot has room for one
+			}
+		})
+	}
+	p.goFunc(func() {
+		defer close(out)
+		for slot := range slots {
+			r, ok := receive(p.ctx, slot)
+			if !ok || !send(p.ctx, out, r) {
+				return
+			}
+		}
+	})
+}
+
+// call calls f, turning a panic into an error.
+func call[In, Out any](ctx context.Context, f func(context.Context, In) (Out, error), v In) (r Out, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("pipeline: stage panicked: %v", x)
+		}
+	}()
+	return f(ctx, v)
+}
+
+// Merge returns a channel sending the values received from all ins, in the order they arrive.
+func Merge[T any](p *Pipeline, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	for _, in := range ins {
+		wg.Add(1)
+		p.goFunc(func() {
+			defer wg.Done()
+			for {
+				v, ok := receive(p.ctx, in)
+				if !ok || !send(p.ctx, out, v) {
+					return
+				}
+			}
+		})
+	}
+	p.goFunc(func() {
+		wg.Wait()
+		close(out)
+	})
+	return out
+}
+
+// Collect receives all values from in, waits for the pipeline and returns the
+// values, or the error that stopped the pipeline.
+func Collect[T any](p *Pipeline, in <-chan T) ([]T, error) {
+	var vs []T
+	for {
+		v, ok := receive(p.ctx, in)
+		if !ok {
+			break
+		}
+		vs = append(vs, v)
+	}
+	if err := p.Wait(); err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// SumExample is SimpleChannelExample as a pipeline: the slice is cut into chunks,
+// two workers sum them, in order, and the sums are printed.
+func SumExample() {
+	fmt.Println("=== Pipeline Sum Example ===")
+
+	s := []int{7, 2, 8, -9, 4, 0}
+	chunks := [][]int{s[:len(s)/2], s[len(s)/2:]}
+
+	p := New(context.Background())
+	sums := Stage(p, Source(p, chunks), func(_ context.Context, chunk []int) (int, error) {
+		sum := 0
+		for _, v := range chunk {
+			sum += v
+		}
+		return sum, nil
+	}, WithWorkers(2), WithOrder())
+	xs, err := Collect(p, sums)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(xs[0], xs[1], xs[0]+xs[1])
+}